@@ -1,12 +1,121 @@
 package dage
 
 import (
+	"context"
 	"github.com/BurntSushi/toml"
 	"github.com/MisakiOfScut/go-dage/internal/core"
 	"github.com/MisakiOfScut/go-dage/internal/script"
 	"github.com/MisakiOfScut/go-dage/internal/utils/executor"
 	"github.com/MisakiOfScut/go-dage/internal/utils/log"
+	"reflect"
 	"strings"
+	"time"
+)
+
+// These types are exported so that users can implement and register operators without importing the internal
+// packages.
+type (
+	Operator            = core.Operator
+	DAGContext          = core.DAGContext
+	NewOperatorFunction = core.NewOperatorFunction
+	OperatorMeta        = core.OperatorMeta
+	RegisterOption      = core.RegisterOption
+	OperatorCall        = core.OperatorCall
+	OperatorFunc        = core.OperatorFunc
+	Interceptor         = core.Interceptor
+	ExecutionResult     = core.ExecutionResult
+	ExecutionStatus     = core.ExecutionStatus
+	VertexResult        = core.VertexResult
+	CompensationResult  = core.CompensationResult
+	Outcome             = core.Outcome
+	Executor            = executor.Executor
+	ExecutorOption      = executor.Option
+	SaturationPolicy    = executor.SaturationPolicy
+	ExecutionInfo       = core.ExecutionInfo
+	PoolStats           = core.PoolStats
+	Priority            = executor.Priority
+	ExecutorStats       = executor.Stats
+	OperatorLimit       = core.OperatorLimit
+	LimitPolicy         = core.LimitPolicy
+	LimiterStats        = core.LimiterStats
+	BreakerOptions      = core.BreakerOptions
+	BreakerState        = core.BreakerState
+	BreakerEvent        = core.BreakerEvent
+	BreakerHook         = core.BreakerHook
+	BreakerStats        = core.BreakerStats
+)
+
+const (
+	DefaultPool = core.DefaultPool
+	InlinePool  = core.InlinePool
+)
+
+const (
+	PolicyBlock      = executor.PolicyBlock
+	PolicyReject     = executor.PolicyReject
+	PolicyCallerRuns = executor.PolicyCallerRuns
+	PolicyGrow       = executor.PolicyGrow
+)
+
+const (
+	BreakerClosed   = core.BreakerClosed
+	BreakerOpen     = core.BreakerOpen
+	BreakerHalfOpen = core.BreakerHalfOpen
+)
+
+const (
+	LimitQueue    = core.LimitQueue
+	LimitFailFast = core.LimitFailFast
+)
+
+const (
+	PriorityBatch       = core.PriorityBatch
+	PriorityNormal      = core.PriorityNormal
+	PriorityInteractive = core.PriorityInteractive
+)
+
+const (
+	ExecutionOk      = core.ExecutionOk
+	ExecutionFailed  = core.ExecutionFailed
+	ExecutionTimeout = core.ExecutionTimeout
+	ExecutionAborted = core.ExecutionAborted
+
+	VertexOk      = core.VertexOk
+	VertexFailed  = core.VertexFailed
+	VertexSkipped = core.VertexSkipped
+)
+
+// ErrAbortGraph can be returned by operators (or wrapped in their errors) to abort the execution immediately.
+var ErrAbortGraph = core.ErrAbortGraph
+
+var (
+	// ErrRejected is the error of executions shed because the executor is saturated, see NewExecutor.
+	ErrRejected = executor.ErrRejected
+	// ErrExecutorStopped is the error of executions after the engine is stopped.
+	ErrExecutorStopped = executor.ErrExecutorStopped
+	// ErrShutdown is returned by executing graphs after Shutdown is called, and is the error of executions aborted
+	// by Shutdown.
+	ErrShutdown = core.ErrShutdown
+	// ErrLimited is the error of vertexes failed by the limit of their operators with LimitFailFast.
+	ErrLimited = core.ErrLimited
+	// ErrCircuitOpen is the error of vertexes failed immediately because the circuit breaker of their operators is
+	// open, so that their next_on_fail vertexes, and the ones on CircuitOpenOutcome, run without waiting for the
+	// operators.
+	ErrCircuitOpen = core.ErrCircuitOpen
+)
+
+// OutcomeKey is the output key of the outcome reported by an operator, see core.OutcomeDeclarer.
+const OutcomeKey = core.OutcomeKey
+
+// CircuitOpenOutcome is the outcome of vertexes failed with ErrCircuitOpen, which routes them to their fallbacks by
+// next_on, e.x. next_on = { circuit_open = ["fallback"] }.
+const CircuitOpenOutcome = core.CircuitOpenOutcome
+
+// CompensateInputPrefix and CompensateOutputPrefix are prefixed to a name which is both an input and an output of a
+// vertex, when the compensating operator of the vertex declares it, see core.CompensateInputPrefix.
+const (
+	CompensateInputPrefix  = core.CompensateInputPrefix
+	CompensateOutputPrefix = core.CompensateOutputPrefix
 )
 
 var (
@@ -26,14 +135,49 @@ func SetLogger(logger log.Logger) {
 }
 
 // Execute a specific graph in a specific graph cluster.
-// 1. You can specify a timeout for the execution,
-// non-positive value will be treated as zero while zero means no timeout.
+// 1. You can specify a timeout for the execution, which overrides the timeout_ms of the graph in script,
+// non-positive value means using the timeout_ms of the graph, and no timeout if neither of them is specified.
 // 2. You can pass a done function(nil is allowed) which will be executed after executing dag
+// 3. If the start vertexes are rejected by the executor, such as ErrRejected, the error is returned and the done
+// function isn't executed.
 func Execute(userData interface{}, graphClusterName string, graphName string,
 	timeoutMillisecond int64, doneClosure func()) error {
 	return _globalE.Execute(userData, graphClusterName, graphName, timeoutMillisecond, doneClosure)
 }
 
+// ExecuteContext executes a graph like Execute, but as a child of ctx. The execution is timeout at the deadline of
+// ctx if it comes first, and the remaining vertexes are skipped after ctx is canceled.
+// Operators can execute nested graphs with ctx.Context() of their DAGContext, so that the nested executions inherit
+// the deadlines of their parents.
+func ExecuteContext(ctx context.Context, userData interface{}, graphClusterName string, graphName string,
+	timeoutMillisecond int64, doneClosure func()) error {
+	return _globalE.ExecuteContext(ctx, userData, graphClusterName, graphName, timeoutMillisecond, doneClosure)
+}
+
+// ExecuteWithResult executes a graph like ExecuteContext, and passes the result of the execution to doneClosure.
+// An execution is aborted when the operator of a critical vertex fails, any operator fails in a fail_fast graph,
+// an operator returns ErrAbortGraph or ctx is canceled. Once aborted, the running operators are canceled through
+// their contexts, the pending vertexes are skipped, and doneClosure is called immediately with ExecutionAborted.
+// Finally vertexes are executed after all other vertexes are done or skipped, even if the execution is timeout or
+// aborted, they can get the results of other vertexes by DAGContext.VertexResults.
+// If the execution isn't ok, the compensate_op of every succeeded vertex is executed in reverse topological order
+// before finally vertexes, and doneClosure is called after compensating even if the execution is aborted.
+// 	e.x.
+// 		[[graph]]
+// 		name = "order"
+// 		[[graph.vertex]]
+// 		op = "lock_stock"
+// 		critical = true
+// 		start = true
+// 		[[graph.vertex]]
+// 		op = "unlock_stock"
+// 		finally = true
+//
+func ExecuteWithResult(ctx context.Context, userData interface{}, graphClusterName string, graphName string,
+	timeoutMillisecond int64, doneClosure func(result *ExecutionResult)) error {
+	return _globalE.ExecuteWithResult(ctx, userData, graphClusterName, graphName, timeoutMillisecond, doneClosure)
+}
+
 // BuildAndSetDAG parse the input script and build an executable dag from it,
 // and this function only returns build error.
 // If you set a dag with a duplicated name, the previous one will be replaced.
@@ -41,27 +185,221 @@ func BuildAndSetDAG(clusterName string, tomlScript *string) error {
 	return _globalE.Build(clusterName, tomlScript)
 }
 
+// Use adds interceptors wrapping the executions of all operators.
+// Interceptors added by Use are the outermost, followed by the ones added by UseForCluster and UseForOperator.
+func Use(interceptors ...Interceptor) {
+	_globalE.Use(interceptors...)
+}
+
+// UseForCluster adds interceptors wrapping the executions of operators in a graph cluster.
+func UseForCluster(graphClusterName string, interceptors ...Interceptor) {
+	_globalE.UseForCluster(graphClusterName, interceptors...)
+}
+
+// UseForOperator adds interceptors wrapping the executions of an operator, which is specified by its id or alias.
+// They wrap the vertexes referring to the operator by any of its ids and aliases.
+func UseForOperator(oprID string, interceptors ...Interceptor) {
+	_globalE.UseForOperator(oprID, interceptors...)
+}
+
+// Warmup prepares n execution contexts for a graph cluster and calls Warmup of every operator in them,
+// so that operators are able to establish their connections before executing graphs.
+func Warmup(graphClusterName string, n int) error {
+	return _globalE.Warmup(graphClusterName, n)
+}
+
 // Stop the engine when your app ends. The engine will be stopped after execute the remaining tasks in the executor's
-// queue. After calling this function, you shouldn't call any other functions, which may cause undefined behaviors.
+// queue, and then operators implementing Closer will be closed. After calling this function, you shouldn't call any
+// other functions, which may cause undefined behaviors.
 func Stop() {
 	_globalE.Stop()
 }
 
+// Shutdown stops the engine gracefully. It stops accepting executions, and waits for the running executions until
+// ctx is done, the executions still running then are aborted with ErrShutdown and returned along with the error of
+// ctx. At last, it stops the engine like Stop.
+// 	e.x.
+// 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// 		defer cancel()
+// 		if aborted, err := dage.Shutdown(ctx); err != nil {
+// 			log.Printf("%d executions are aborted: %v", len(aborted), err)
+// 		}
+//
+func Shutdown(ctx context.Context) ([]ExecutionInfo, error) {
+	return _globalE.Shutdown(ctx)
+}
+
 func DumpDAGDot(graphClusterName string) string {
 	return _globalE.DumpDAGDot(graphClusterName)
 }
 
+// NewExecutor creates an executor running tasks in concurrentLevel goroutines with a queue of queueLength tasks.
+// When the queue is full, tasks are handled with the saturation policy, which is PolicyCallerRuns by default, so that
+// the vertexes are executed in the goroutines submitting them instead of waiting for the workers submitting them:
+// 	e.x.
+// 		dage.ReplaceExecutor(dage.NewExecutor(128, 16, dage.WithSaturationPolicy(dage.PolicyReject)))
+//
+// Executing graphs returns ErrRejected if their start vertexes are rejected, and executions whose other vertexes are
+// rejected are aborted with ErrRejected.
+func NewExecutor(queueLength uint, concurrentLevel uint, opts ...ExecutorOption) Executor {
+	return executor.NewDefaultExecutor(queueLength, concurrentLevel, opts...)
+}
+
+// NewPriorityExecutor creates an executor like NewExecutor, except that the queued tasks are executed by priority.
+// Vertexes are prioritized by the priority class of their executions first, and then by their remaining critical
+// paths, which are estimated by the weight settings of vertexes and their observed latencies:
+// 	e.x.
+// 		dage.ReplaceExecutor(dage.NewPriorityExecutor(128, 16))
+// 		dage.ExecuteContext(dage.WithPriorityClass(ctx, dage.PriorityInteractive), ...)
+func NewPriorityExecutor(queueLength uint, concurrentLevel uint, opts ...ExecutorOption) Executor {
+	return executor.NewPriorityExecutor(queueLength, concurrentLevel, opts...)
+}
+
+// NewWorkStealingExecutor creates an executor like NewExecutor, except that every goroutine has its own queue
+// besides the shared queue of queueLength tasks. Vertexes becoming ready in a goroutine are pushed to its own queue
+// and stolen by idle goroutines, which reduces the contention on the shared queue at high QPS:
+// 	e.x.
+// 		dage.ReplaceExecutor(dage.NewWorkStealingExecutor(128, 16))
+func NewWorkStealingExecutor(queueLength uint, concurrentLevel uint, opts ...ExecutorOption) Executor {
+	return executor.NewWorkStealingExecutor(queueLength, concurrentLevel, opts...)
+}
+
+// WithPriorityClass returns a child of ctx, executions executed with it have the priority class, such as
+// PriorityInteractive or PriorityBatch. The class takes effect in the pools of priority executors.
+func WithPriorityClass(ctx context.Context, class int) context.Context {
+	return core.WithPriorityClass(ctx, class)
+}
+
+// WithSaturationPolicy sets how an executor handles tasks submitted when its queue is full.
+func WithSaturationPolicy(policy SaturationPolicy) ExecutorOption {
+	return executor.WithSaturationPolicy(policy)
+}
+
+// WithMaxTemporaryWorkers limits the temporary goroutines of PolicyGrow, which equals to concurrentLevel by default.
+func WithMaxTemporaryWorkers(n uint) ExecutorOption {
+	return executor.WithMaxTemporaryWorkers(n)
+}
+
+// WithAutoscaling makes the workers of an executor created by NewExecutor scale between min and max. A worker is
+// added when a vertex has waited in the queue longer than targetWait, and a worker exits after being idle for
+// idleTimeout:
+// 	e.x.
+// 		dage.ReplaceExecutor(dage.NewExecutor(128, 8, dage.WithAutoscaling(4, 64, 5*time.Millisecond, time.Minute)))
+func WithAutoscaling(min uint, max uint, targetWait time.Duration, idleTimeout time.Duration) ExecutorOption {
+	return executor.WithAutoscaling(min, max, targetWait, idleTimeout)
+}
+
+// ResizeExecutor sets the number of goroutines of the executor of a pool, the pool is DefaultPool if it's empty.
+// The executor should be created by NewExecutor, and the number is clamped between the limits of WithAutoscaling.
+func ResizeExecutor(pool string, n uint) error {
+	return _globalE.ResizeExecutor(pool, n)
+}
+
+// GetExecutorStats returns the goroutines, the queue depth and the queue wait time of the executor of a pool, the
+// pool is DefaultPool if it's empty. The executor should be created by NewExecutor.
+func GetExecutorStats(pool string) (ExecutorStats, error) {
+	return _globalE.ExecutorStats(pool)
+}
+
 // ReplaceExecutor replace the executor of the engine.
 // The default executor is created with 32 queueLength and 8 concurrentLevel.
-// You can call this function before executing graphs.
+// It's safe to call this function while executing graphs, the previous executor is stopped after processing the
+// vertexes submitted to it, and the following vertexes are submitted to the new executor.
 func ReplaceExecutor(executor executor.Executor) {
 	_globalE.ReplaceTaskExecutor(executor)
 }
 
+// RegisterExecutor registers a named executor pool, scripts select it by the pool setting of graphs or vertexes:
+// 	e.x.
+// 		dage.RegisterExecutor("io", dage.NewExecutor(256, 64))
+// 		[[graph.vertex]]
+// 		op = "fetch_profile"
+// 		pool = "io"
+//
+// Pools should be registered before building the scripts using them. Vertexes without pool are executed by the
+// DefaultPool, and vertexes in the InlinePool are executed in the goroutine completing their deps.
+func RegisterExecutor(name string, executor Executor) error {
+	return _globalE.RegisterExecutor(name, executor)
+}
+
+// GetPoolStats returns the statistics of all executor pools.
+func GetPoolStats() []PoolStats {
+	return _globalE.PoolStats()
+}
+
 // RegisterOperator add an operator object new function to engine.
-// Attention: add a function with duplicated name will replace the previous one;
-func RegisterOperator(oprName string, fun core.NewOperatorFunction) {
-	_globalOprMgr.RegisterOperator(oprName, fun)
+// The operator id can have a namespace and a version, e.x. "ranking.v2/score@1.0.2", and scripts can refer to it
+// by "ranking.v2/score@1.0.2", or by "ranking.v2/score" which means the highest registered version, e.x. "1.10" is
+// higher than "1.9" and the operator registered without version is lower than any version.
+// Attention: add a function with duplicated id will replace the previous one,
+// unless SetRejectDuplicateOperator(true) is called;
+func RegisterOperator(oprName string, fun NewOperatorFunction, opts ...RegisterOption) error {
+	return _globalOprMgr.RegisterOperator(oprName, fun, opts...)
+}
+
+// SetRejectDuplicateOperator makes RegisterOperator return an error when registering a duplicated operator id.
+func SetRejectDuplicateOperator(reject bool) {
+	_globalOprMgr.SetRejectDuplicate(reject)
+}
+
+// AliasOperator makes scripts able to refer to the operator target by alias.
+func AliasOperator(alias string, target string) error {
+	return _globalOprMgr.Alias(alias, target)
+}
+
+// ListOperators returns the metadata of all registered operators.
+func ListOperators() []OperatorMeta {
+	return _globalOprMgr.ListOperators()
+}
+
+func WithOperatorVersion(version string) RegisterOption {
+	return core.WithVersion(version)
+}
+
+func WithOperatorDescription(description string) RegisterOption {
+	return core.WithDescription(description)
+}
+
+func WithOperatorAliases(aliases ...string) RegisterOption {
+	return core.WithAliases(aliases...)
+}
+
+// WithOperatorLimit throttles the registered operator in all executions of all graph clusters:
+// 	e.x.
+// 		dage.RegisterOperator("fetch_profile", newFetchProfile,
+// 			dage.WithOperatorLimit(dage.OperatorLimit{MaxConcurrency: 16, Rate: 100, Policy: dage.LimitFailFast}))
+//
+// Vertexes wait for the limit until they are timeout with LimitQueue, or fail immediately with ErrLimited with
+// LimitFailFast. The limit can be overridden by the [[limit]] tables of scripts, see script.Limit.
+func WithOperatorLimit(limit OperatorLimit) RegisterOption {
+	return core.WithLimit(limit)
+}
+
+// WithOperatorBreaker makes the registered operator have a circuit breaker shared by all executions of all graph
+// clusters. The breaker opens when the error rate of the operator reaches the threshold, then vertexes of the operator
+// fail with ErrCircuitOpen immediately until probes succeed after the open duration:
+// 	e.x.
+// 		dage.RegisterOperator("fetch_profile", newFetchProfile,
+// 			dage.WithOperatorBreaker(dage.BreakerOptions{ErrorRate: 0.5, OpenDuration: 5 * time.Second}))
+//
+func WithOperatorBreaker(options BreakerOptions) RegisterOption {
+	return core.WithBreaker(options)
+}
+
+// OnBreakerStateChange adds a hook called after the state of a circuit breaker changed, the changes are logged as
+// well.
+func OnBreakerStateChange(hook BreakerHook) {
+	_globalE.OnBreakerStateChange(hook)
+}
+
+// GetBreakerStats returns the states of all circuit breakers.
+func GetBreakerStats() []BreakerStats {
+	return _globalE.BreakerStats()
+}
+
+// GetLimiterStats returns the usage of the limits of all limited operators.
+func GetLimiterStats() []LimiterStats {
+	return _globalE.LimiterStats()
 }
 
 type mockGraphManager struct {
@@ -76,6 +414,15 @@ func (p *mockGraphManager) GetOperatorInputs(oprName string) []string {
 func (p *mockGraphManager) GetOperatorOutputs(oprName string) []string {
 	return nil
 }
+func (p *mockGraphManager) GetOperatorInputTypes(oprName string) map[string]reflect.Type {
+	return nil
+}
+func (p *mockGraphManager) GetOperatorOutputTypes(oprName string) map[string]reflect.Type {
+	return nil
+}
+func (p *mockGraphManager) GetOperatorOutcomes(oprName string) []string {
+	return nil
+}
 func (p *mockGraphManager) IsProduction() bool {
 	return false
 }
@@ -0,0 +1,55 @@
+package dage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplaceExecutor_Saturated(t *testing.T) {
+	// the only worker submits the next vertexes to its full queue, which runs them instead of waiting for itself
+	ReplaceExecutor(NewExecutor(1, 1))
+	defer ReplaceExecutor(NewExecutor(32, 8))
+	script := `
+[[graph]]
+name = "fan_out"
+
+[[graph.vertex]]
+id = "fan"
+op = "dage/noop"
+start = true
+next = ["a", "b", "c", "d"]
+
+[[graph.vertex]]
+id = "a"
+op = "dage/noop"
+
+[[graph.vertex]]
+id = "b"
+op = "dage/noop"
+
+[[graph.vertex]]
+id = "c"
+op = "dage/noop"
+
+[[graph.vertex]]
+id = "d"
+op = "dage/noop"
+`
+	if err := BuildAndSetDAG("saturated_cluster", &script); err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan *ExecutionResult, 1)
+	if err := ExecuteWithResult(nil, nil, "saturated_cluster", "fan_out", 0, func(result *ExecutionResult) {
+		results <- result
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case result := <-results:
+		if result.Status != ExecutionOk {
+			t.Fatalf("unexpected result:%+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("execution in a saturated executor shouldn't deadlock")
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/MisakiOfScut/go-dage
 
-go 1.17
+go 1.18
 
 require (
 	github.com/BurntSushi/toml v1.0.0
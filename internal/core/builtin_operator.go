@@ -0,0 +1,506 @@
+package core
+
+import (
+	"fmt"
+	"github.com/MisakiOfScut/go-dage/internal/utils/eval"
+	"github.com/MisakiOfScut/go-dage/internal/utils/log"
+	"sort"
+	"time"
+)
+
+// Builtin operators, they accept any input declared in the vertex, and their outputs must be declared in the vertex.
+// 	e.x.
+// 		[[graph.vertex]]
+// 		id = "merge"
+// 		op = "dage/merge_maps"
+// 		input = [{name = "user_features", id = "user_features"}, {name = "item_features", id = "item_features"}]
+// 		output = [{name = "merged", id = "features"}]
+// 		args = { order = ["user_features", "item_features"] }
+//
+const (
+	BuiltinNoop        = "dage/noop"
+	BuiltinSleep       = "dage/sleep"
+	BuiltinSetParam    = "dage/set_param"
+	BuiltinLog         = "dage/log"
+	BuiltinAssert      = "dage/assert"
+	BuiltinCopy        = "dage/copy"
+	BuiltinRename      = "dage/rename"
+	BuiltinMergeMaps   = "dage/merge_maps"
+	BuiltinCollectList = "dage/collect_list"
+	BuiltinFail        = "dage/fail"
+)
+
+func (m *defaultOperatorManager) addBuiltinOpr() {
+	builtins := []struct {
+		name        string
+		newFunc     NewOperatorFunction
+		description string
+		args        map[string]string
+	}{
+		{BuiltinNoop, func() Operator { return &noopOperator{} }, "does nothing", nil},
+		{BuiltinSleep, func() Operator { return &sleepOperator{} }, "sleeps for a while",
+			map[string]string{"duration_ms": "sleep time in milliseconds"}},
+		{BuiltinSetParam, func() Operator { return &setParamOperator{} },
+			"assigns dag params from expressions evaluated with dag params",
+			map[string]string{"params": "a table mapping param name to expression, e.x. { score = \"a * 0.8\" }"}},
+		{BuiltinLog, func() Operator { return &logOperator{} }, "logs a message and the inputs",
+			map[string]string{"message": "message to log", "level": "debug, info(default), warn or error"}},
+		{BuiltinAssert, func() Operator { return &assertOperator{} },
+			"fails the vertex when the expression evaluated with dag params is false",
+			map[string]string{"expr": "bool expression", "message": "error message when the expression is false"}},
+		{BuiltinCopy, func() Operator { return &copyOperator{keepSource: true} },
+			"outputs every input with its name, and copies the mapped inputs to new names",
+			map[string]string{"mapping": "a table mapping input name to output name"}},
+		{BuiltinRename, func() Operator { return &copyOperator{keepSource: false} },
+			"outputs every input with its name, except that the mapped inputs are renamed",
+			map[string]string{"mapping": "a table mapping input name to output name"}},
+		{BuiltinMergeMaps, func() Operator { return &mergeMapsOperator{} },
+			"merges the inputs of type map[string]interface{} into one map, the latter input overrides the former",
+			map[string]string{"output": "output name, \"merged\" by default",
+				"order": "input names in merging order, sorted input names by default"}},
+		{BuiltinCollectList, func() Operator { return &collectListOperator{} },
+			"collects the inputs into a []interface{}",
+			map[string]string{"output": "output name, \"list\" by default",
+				"order": "input names in collecting order, sorted input names by default"}},
+		{BuiltinFail, func() Operator { return &failOperator{} }, "always fails",
+			map[string]string{"message": "error message"}},
+		{BuiltinSubprocess, func() Operator { return &subprocessOperator{} },
+			"runs a command with the inputs as a json object in stdin, and the outputs as a json object in stdout",
+			map[string]string{"command": "command line, e.x. [\"python3\", \"score.py\"]",
+				"timeout_ms": "kill the command after timeout, the execution's deadline is always honoured",
+				"worker": "keep the command alive and send newline-delimited json requests to it"}},
+	}
+	for _, b := range builtins {
+		_ = m.RegisterOperator(b.name, b.newFunc, WithDescription(b.description), WithArgs(b.args))
+	}
+}
+
+// builtinOperator stores the injected inputs, the other builtin operators are based on it
+type builtinOperator struct {
+	inputs map[string]interface{}
+}
+
+func (p *builtinOperator) InjectDepsData(key string, value interface{}) error {
+	if p.inputs == nil {
+		p.inputs = make(map[string]interface{})
+	}
+	p.inputs[key] = value
+	return nil
+}
+func (p *builtinOperator) GetInputsID() []string {
+	return nil
+}
+func (p *builtinOperator) GetOutputsID() []string {
+	return nil
+}
+func (p *builtinOperator) Configure(args map[string]interface{}) error {
+	if len(args) > 0 {
+		return fmt.Errorf("doesn't accept args")
+	}
+	return nil
+}
+func (p *builtinOperator) reset() {
+	for k := range p.inputs {
+		delete(p.inputs, k)
+	}
+}
+
+// sortedInputNames returns order if it isn't empty, otherwise returns the sorted names of inputs
+func (p *builtinOperator) sortedInputNames(order []string) []string {
+	if len(order) > 0 {
+		return order
+	}
+	names := make([]string, 0, len(p.inputs))
+	for name := range p.inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type noopOperator struct {
+	builtinOperator
+}
+
+func (p *noopOperator) Name() string {
+	return BuiltinNoop
+}
+func (p *noopOperator) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	return nil, nil
+}
+func (p *noopOperator) Reset() Operator {
+	p.reset()
+	return p
+}
+
+type sleepOperator struct {
+	builtinOperator
+	duration time.Duration
+}
+
+func (p *sleepOperator) Name() string {
+	return BuiltinSleep
+}
+func (p *sleepOperator) Configure(args map[string]interface{}) error {
+	ms, err := argInt64(args, "duration_ms", 0)
+	if err != nil {
+		return err
+	}
+	p.duration = time.Duration(ms) * time.Millisecond
+	return nil
+}
+func (p *sleepOperator) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	timer := time.NewTimer(p.duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil, nil
+	case <-ctx.Context().Done():
+		return nil, ctx.Context().Err()
+	}
+}
+func (p *sleepOperator) Reset() Operator {
+	p.reset()
+	return p
+}
+
+type setParamOperator struct {
+	builtinOperator
+	names []string
+	exprs []eval.EvaluableExpression
+}
+
+func (p *setParamOperator) Name() string {
+	return BuiltinSetParam
+}
+func (p *setParamOperator) Configure(args map[string]interface{}) error {
+	params, err := argStringMap(args, "params")
+	if err != nil {
+		return err
+	}
+	if len(params) == 0 {
+		return fmt.Errorf("arg params is required")
+	}
+	for name := range params {
+		p.names = append(p.names, name)
+	}
+	sort.Strings(p.names)
+	for _, name := range p.names {
+		expr, err := eval.NewEvaluableExpression(params[name])
+		if err != nil {
+			return fmt.Errorf("param:%s, expression:%s parsed failed with err:%v", name, params[name], err)
+		}
+		p.exprs = append(p.exprs, expr)
+	}
+	return nil
+}
+func (p *setParamOperator) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	for i, name := range p.names {
+		value, err := ctx.DoEval(p.exprs[i])
+		if err != nil {
+			return nil, fmt.Errorf("param:%s, evaluate %s failed with err:%v", name, p.exprs[i].String(), err)
+		}
+		if err = ctx.SetParams(name, value); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+func (p *setParamOperator) Reset() Operator {
+	p.reset()
+	return p
+}
+
+type logOperator struct {
+	builtinOperator
+	message string
+	logf    func(format string, v ...interface{})
+}
+
+func (p *logOperator) Name() string {
+	return BuiltinLog
+}
+func (p *logOperator) Configure(args map[string]interface{}) error {
+	var err error
+	if p.message, err = argString(args, "message", ""); err != nil {
+		return err
+	}
+	level, err := argString(args, "level", "info")
+	if err != nil {
+		return err
+	}
+	switch level {
+	case "debug":
+		p.logf = log.Debugf
+	case "info":
+		p.logf = log.Infof
+	case "warn":
+		p.logf = log.Warnf
+	case "error":
+		p.logf = log.Errorf
+	default:
+		return fmt.Errorf("unknown log level:%s", level)
+	}
+	return nil
+}
+func (p *logOperator) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	p.logf("%s, inputs:%v", p.message, p.inputs)
+	return nil, nil
+}
+func (p *logOperator) Reset() Operator {
+	p.reset()
+	return p
+}
+
+type assertOperator struct {
+	builtinOperator
+	expr    eval.EvaluableExpression
+	message string
+}
+
+func (p *assertOperator) Name() string {
+	return BuiltinAssert
+}
+func (p *assertOperator) Configure(args map[string]interface{}) error {
+	exprStr, err := argString(args, "expr", "")
+	if err != nil {
+		return err
+	}
+	if len(exprStr) == 0 {
+		return fmt.Errorf("arg expr is required")
+	}
+	if p.expr, err = eval.NewEvaluableExpression(exprStr); err != nil {
+		return fmt.Errorf("expr:%s parsed failed with err:%v", exprStr, err)
+	}
+	p.message, err = argString(args, "message", "assertion failed: "+exprStr)
+	return err
+}
+func (p *assertOperator) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	result, err := ctx.DoEval(p.expr)
+	if err != nil {
+		return nil, fmt.Errorf("evaluate %s failed with err:%v", p.expr.String(), err)
+	}
+	if r, ok := result.(bool); !ok {
+		return nil, fmt.Errorf("%s is not a bool expression", p.expr.String())
+	} else if !r {
+		return nil, fmt.Errorf("%s", p.message)
+	}
+	return nil, nil
+}
+func (p *assertOperator) Reset() Operator {
+	p.reset()
+	return p
+}
+
+// copyOperator is used by both dage/copy and dage/rename
+type copyOperator struct {
+	builtinOperator
+	keepSource bool
+	mapping    map[string]string
+}
+
+func (p *copyOperator) Name() string {
+	if p.keepSource {
+		return BuiltinCopy
+	}
+	return BuiltinRename
+}
+func (p *copyOperator) Configure(args map[string]interface{}) error {
+	var err error
+	if p.mapping, err = argStringMap(args, "mapping"); err != nil {
+		return err
+	}
+	if len(p.mapping) == 0 {
+		return fmt.Errorf("arg mapping is required")
+	}
+	return nil
+}
+func (p *copyOperator) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	outputs := make(map[string]interface{}, len(p.inputs)+len(p.mapping))
+	for name, value := range p.inputs {
+		target, mapped := p.mapping[name]
+		if mapped {
+			outputs[target] = value
+		}
+		if !mapped || p.keepSource {
+			outputs[name] = value
+		}
+	}
+	return outputs, nil
+}
+func (p *copyOperator) Reset() Operator {
+	p.reset()
+	return p
+}
+
+type mergeMapsOperator struct {
+	builtinOperator
+	output string
+	order  []string
+}
+
+func (p *mergeMapsOperator) Name() string {
+	return BuiltinMergeMaps
+}
+func (p *mergeMapsOperator) Configure(args map[string]interface{}) error {
+	var err error
+	if p.output, err = argString(args, "output", "merged"); err != nil {
+		return err
+	}
+	p.order, err = argStringSlice(args, "order")
+	return err
+}
+func (p *mergeMapsOperator) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	merged := make(map[string]interface{})
+	for _, name := range p.sortedInputNames(p.order) {
+		value, existed := p.inputs[name]
+		if !existed {
+			return nil, fmt.Errorf("missed input:%s", name)
+		}
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("input:%s is %T, not a map[string]interface{}", name, value)
+		}
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return map[string]interface{}{p.output: merged}, nil
+}
+func (p *mergeMapsOperator) Reset() Operator {
+	p.reset()
+	return p
+}
+
+type collectListOperator struct {
+	builtinOperator
+	output string
+	order  []string
+}
+
+func (p *collectListOperator) Name() string {
+	return BuiltinCollectList
+}
+func (p *collectListOperator) Configure(args map[string]interface{}) error {
+	var err error
+	if p.output, err = argString(args, "output", "list"); err != nil {
+		return err
+	}
+	p.order, err = argStringSlice(args, "order")
+	return err
+}
+func (p *collectListOperator) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	names := p.sortedInputNames(p.order)
+	list := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		value, existed := p.inputs[name]
+		if !existed {
+			return nil, fmt.Errorf("missed input:%s", name)
+		}
+		list = append(list, value)
+	}
+	return map[string]interface{}{p.output: list}, nil
+}
+func (p *collectListOperator) Reset() Operator {
+	p.reset()
+	return p
+}
+
+type failOperator struct {
+	builtinOperator
+	message string
+}
+
+func (p *failOperator) Name() string {
+	return BuiltinFail
+}
+func (p *failOperator) Configure(args map[string]interface{}) error {
+	var err error
+	p.message, err = argString(args, "message", "failed by "+BuiltinFail)
+	return err
+}
+func (p *failOperator) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	return nil, fmt.Errorf("%s", p.message)
+}
+func (p *failOperator) Reset() Operator {
+	p.reset()
+	return p
+}
+
+// helpers of parsing vertex args, which are decoded from toml
+
+func argString(args map[string]interface{}, key string, defaultValue string) (string, error) {
+	v, ok := args[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("arg %s should be a string, but got %T", key, v)
+	}
+	return s, nil
+}
+
+func argInt64(args map[string]interface{}, key string, defaultValue int64) (int64, error) {
+	v, ok := args[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	i, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("arg %s should be an integer, but got %T", key, v)
+	}
+	return i, nil
+}
+
+func argBool(args map[string]interface{}, key string, defaultValue bool) (bool, error) {
+	v, ok := args[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("arg %s should be a bool, but got %T", key, v)
+	}
+	return b, nil
+}
+
+func argStringSlice(args map[string]interface{}, key string) ([]string, error) {
+	v, ok := args[key]
+	if !ok {
+		return nil, nil
+	}
+	slice, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("arg %s should be an array of strings, but got %T", key, v)
+	}
+	result := make([]string, 0, len(slice))
+	for _, e := range slice {
+		s, ok := e.(string)
+		if !ok {
+			return nil, fmt.Errorf("arg %s should be an array of strings, but got an element of %T", key, e)
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func argStringMap(args map[string]interface{}, key string) (map[string]string, error) {
+	v, ok := args[key]
+	if !ok {
+		return nil, nil
+	}
+	table, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("arg %s should be a table of strings, but got %T", key, v)
+	}
+	result := make(map[string]string, len(table))
+	for k, e := range table {
+		s, ok := e.(string)
+		if !ok {
+			return nil, fmt.Errorf("arg %s.%s should be a string, but got %T", key, k, e)
+		}
+		result[k] = s
+	}
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"fmt"
+	"github.com/MisakiOfScut/go-dage/internal/utils/executor"
+	"testing"
+)
+
+// captureOpr sends its input "list" to a channel
+type captureOpr struct {
+	builtinOperator
+	ch chan interface{}
+}
+
+func (p *captureOpr) Name() string {
+	return "captureOpr"
+}
+func (p *captureOpr) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	p.ch <- p.inputs["list"]
+	return nil, nil
+}
+func (p *captureOpr) GetInputsID() []string {
+	return []string{"list"}
+}
+func (p *captureOpr) Reset() Operator {
+	p.reset()
+	return p
+}
+
+func TestBuiltinOperator_Graph(t *testing.T) {
+	ch := make(chan interface{}, 1)
+	oprMgr := NewDefaultOperatorManager()
+	_ = oprMgr.RegisterOperator("DataOperator1", func() Operator { return &DataOperator1{} })
+	_ = oprMgr.RegisterOperator("captureOpr", func() Operator { return &captureOpr{ch: ch} })
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	script := `
+[[graph]]
+name = "test_builtin"
+
+[[graph.vertex]]
+id = "init"
+op = "dage/set_param"
+args = { params = { a = "1 + 2", b = "a * 2" } }
+start = true
+
+[[graph.vertex]]
+id = "check"
+op = "dage/assert"
+args = { expr = "a == 3" }
+deps = ["init"]
+next_on_ok = ["DataOperator1"]
+
+[[graph.vertex]]
+op = "DataOperator1"
+
+[[graph.vertex]]
+id = "copy"
+op = "dage/copy"
+input = [{name = "d1", id = "d1"}]
+output = [{name = "d1", id = "d1_copy"}, {name = "x", id = "x"}]
+args = { mapping = { d1 = "x" } }
+
+[[graph.vertex]]
+id = "collect"
+op = "dage/collect_list"
+input = [{name = "d1", id = "d1_copy"}, {name = "x", id = "x"}, {name = "d2", id = "d2"}]
+output = [{name = "list", id = "list"}]
+args = { order = ["d1", "x", "d2"] }
+
+[[graph.vertex]]
+op = "captureOpr"
+`
+	if err := m.Build(graphClusterName, &script); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Execute(nil, graphClusterName, "test_builtin", 0, func() {
+		close(ch)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if list := <-ch; fmt.Sprint(list) != "[1 1 Hello from DataOperator1]" {
+		t.Fatalf("unexpected list:%v", list)
+	}
+}
+
+func TestBuiltinOperator_Configure(t *testing.T) {
+	cases := map[string]string{
+		"unknown arg type":   `op = "dage/sleep"` + "\n" + `args = { duration_ms = "10" }`,
+		"missing arg":        `op = "dage/assert"`,
+		"illegal expression": `op = "dage/set_param"` + "\n" + `args = { params = { a = "1 +" } }`,
+		"unexpected args":    `op = "dage/noop"` + "\n" + `args = { a = 1 }`,
+		"not configurable":   `op = "DataOperator1"` + "\n" + `args = { a = 1 }`,
+	}
+	oprMgr := NewDefaultOperatorManager()
+	_ = oprMgr.RegisterOperator("DataOperator1", func() Operator { return &DataOperator1{} })
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	for name, vertex := range cases {
+		script := "[[graph]]\nname = \"test_builtin\"\n[[graph.vertex]]\nstart = true\n" + vertex
+		if err := m.Build(graphClusterName, &script); err == nil {
+			t.Fatalf("case:%s should fail", name)
+		} else {
+			t.Logf("case:%s, %v", name, err)
+		}
+	}
+}
+
+func TestBuiltinOperator_Execute(t *testing.T) {
+	ctx := &DAGContext{dagParams: newDagParams()}
+
+	merge := &mergeMapsOperator{}
+	if err := merge.Configure(map[string]interface{}{"order": []interface{}{"b", "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	_ = merge.InjectDepsData("a", map[string]interface{}{"k": 1, "a": 1})
+	_ = merge.InjectDepsData("b", map[string]interface{}{"k": 2, "b": 2})
+	outputs, err := merge.OnExecute(ctx)
+	if err != nil || fmt.Sprint(outputs) != "map[merged:map[a:1 b:2 k:1]]" {
+		t.Fatalf("unexpected outputs:%v, err:%v", outputs, err)
+	}
+	_ = merge.Reset().InjectDepsData("a", 1)
+	if _, err = merge.OnExecute(ctx); err == nil {
+		t.Fatal("merging a non-map input should fail")
+	}
+
+	rename := &copyOperator{}
+	_ = rename.Configure(map[string]interface{}{"mapping": map[string]interface{}{"a": "b"}})
+	_ = rename.InjectDepsData("a", 1)
+	_ = rename.InjectDepsData("c", 3)
+	if outputs, _ = rename.OnExecute(ctx); fmt.Sprint(outputs) != "map[b:1 c:3]" {
+		t.Fatalf("unexpected outputs:%v", outputs)
+	}
+
+	fail := &failOperator{}
+	_ = fail.Configure(map[string]interface{}{"message": "boom"})
+	if _, err = fail.OnExecute(ctx); err == nil || err.Error() != "boom" {
+		t.Fatalf("unexpected err:%v", err)
+	}
+
+	assert := &assertOperator{}
+	_ = assert.Configure(map[string]interface{}{"expr": "a > 1", "message": "a is too small"})
+	_ = ctx.SetParams("a", 1)
+	if _, err = assert.OnExecute(ctx); err == nil || err.Error() != "a is too small" {
+		t.Fatalf("unexpected err:%v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"context"
+	"go.uber.org/atomic"
+	"sort"
+	"sync"
+	"time"
+)
+
+// executionRegistry tracks the running executions of a GraphManager, so that shutting down can wait for them
+type executionRegistry struct {
+	lock       sync.Mutex
+	executions map[*graphContext]*execution
+	idle       chan struct{} // closed when there are no running executions, created by wait
+	closed     atomic.Bool   // no more executions are accepted
+}
+
+type execution struct {
+	info     ExecutionInfo
+	aborting bool   // being aborted by abortAll without the lock
+	recycle  func() // recycles the context of the execution ended while it's being aborted, called after aborting
+}
+
+func newExecutionRegistry() *executionRegistry {
+	return &executionRegistry{executions: make(map[*graphContext]*execution)}
+}
+
+func (r *executionRegistry) add(gc *graphContext, cluster string, graph string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed.Load() {
+		return ErrShutdown
+	}
+	r.executions[gc] = &execution{info: ExecutionInfo{Cluster: cluster, Graph: graph, StartTime: time.Now()}}
+	return nil
+}
+
+// remove the ended execution and recycle its context. If the execution is being aborted, they are deferred until
+// aborting returns, so that the context isn't reused by another execution when it's being aborted.
+func (r *executionRegistry) remove(gc *graphContext, recycle func()) {
+	r.lock.Lock()
+	if e, ok := r.executions[gc]; ok && e.aborting {
+		e.recycle = recycle
+		r.lock.Unlock()
+		return
+	}
+	r.delete(gc)
+	r.lock.Unlock()
+	recycle()
+}
+
+// delete an execution, the lock should be held
+func (r *executionRegistry) delete(gc *graphContext) {
+	delete(r.executions, gc)
+	if len(r.executions) == 0 && r.idle != nil {
+		close(r.idle)
+		r.idle = nil
+	}
+}
+
+// close makes the registry reject new executions
+func (r *executionRegistry) close() {
+	r.closed.Store(true)
+}
+
+// wait until there are no running executions or ctx is done
+func (r *executionRegistry) wait(ctx context.Context) error {
+	r.lock.Lock()
+	if len(r.executions) == 0 {
+		r.lock.Unlock()
+		return nil
+	}
+	if r.idle == nil {
+		r.idle = make(chan struct{})
+	}
+	idle := r.idle
+	r.lock.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// abortAll aborts the running executions with err, and returns them in the order they started. The executions are
+// aborted without the lock, since aborting calls their done closures, which may call the registry.
+func (r *executionRegistry) abortAll(err error) []ExecutionInfo {
+	r.lock.Lock()
+	contexts := make([]*graphContext, 0, len(r.executions))
+	aborted := make([]ExecutionInfo, 0, len(r.executions))
+	for gc, e := range r.executions {
+		e.aborting = true
+		contexts = append(contexts, gc)
+		aborted = append(aborted, e.info)
+	}
+	r.lock.Unlock()
+
+	for _, gc := range contexts {
+		gc.abort("", err)
+	}
+
+	var recycles []func()
+	r.lock.Lock()
+	for _, gc := range contexts {
+		e := r.executions[gc]
+		e.aborting = false
+		if e.recycle != nil {
+			recycles = append(recycles, e.recycle)
+			r.delete(gc)
+		}
+	}
+	r.lock.Unlock()
+	for _, recycle := range recycles {
+		recycle()
+	}
+
+	sort.Slice(aborted, func(i, j int) bool {
+		return aborted[i].StartTime.Before(aborted[j].StartTime)
+	})
+	return aborted
+}
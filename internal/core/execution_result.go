@@ -0,0 +1,87 @@
+package core
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrAbortGraph can be returned by operators (or wrapped in their errors) to abort the execution of the graph
+// immediately, the operator won't be retried.
+var ErrAbortGraph = errors.New("abort graph")
+
+// ErrShutdown is returned by executing graphs after the GraphManager starts shutting down, and is the error of
+// executions aborted by the shutdown.
+var ErrShutdown = errors.New("graph manager is shut down")
+
+type ExecutionStatus int
+
+const (
+	ExecutionOk      ExecutionStatus = iota // all operators succeeded
+	ExecutionFailed                         // some operators failed
+	ExecutionTimeout                        // some vertexes were skipped because of the timeout
+	ExecutionAborted                        // the execution was aborted, the remaining vertexes were skipped
+)
+
+func (s ExecutionStatus) String() string {
+	switch s {
+	case ExecutionOk:
+		return "ok"
+	case ExecutionFailed:
+		return "failed"
+	case ExecutionTimeout:
+		return "timeout"
+	case ExecutionAborted:
+		return "aborted"
+	}
+	return "unknown"
+}
+
+// ExecutionResult is passed to the done callback of ExecuteWithResult.
+type ExecutionResult struct {
+	Status ExecutionStatus
+	// Err is the error aborting the execution if the execution is aborted, context.DeadlineExceeded if timeout,
+	// or the first error returned by operators if failed.
+	Err error
+	// Vertex is the id of the vertex which returned Err, empty if Err isn't returned by an operator.
+	Vertex string
+	// Compensations are the executions of compensating operators, in the order they were executed.
+	Compensations []CompensationResult
+	// Buckets maps the id of every executed split vertex to the bucket which the execution is assigned to.
+	Buckets map[string]string
+}
+
+// CompensationResult is the result of a compensating operator.
+type CompensationResult struct {
+	Vertex   string // the vertex being compensated
+	Operator string // compensate_op of the vertex
+	Err      error
+}
+
+// VertexResult is the result of a vertex in an execution, which is available in finally vertexes through
+// DAGContext.VertexResults.
+type VertexResult int
+
+const (
+	VertexOk      VertexResult = iota // the operator succeeded, or the condition is true
+	VertexFailed                      // the operator failed, or the condition is false
+	VertexSkipped                     // the vertex wasn't executed because of its deps, the timeout or aborting
+)
+
+func (r VertexResult) String() string {
+	switch r {
+	case VertexOk:
+		return "ok"
+	case VertexFailed:
+		return "failed"
+	case VertexSkipped:
+		return "skipped"
+	}
+	return "unknown"
+}
+
+// ExecutionInfo describes a running execution.
+type ExecutionInfo struct {
+	Cluster   string
+	Graph     string
+	StartTime time.Time
+}
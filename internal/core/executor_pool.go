@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/MisakiOfScut/go-dage/internal/utils/executor"
+	"go.uber.org/atomic"
+	"sync"
+)
+
+const (
+	DefaultPool = "default" // the pool of vertexes without pool
+	InlinePool  = "inline"  // vertexes in this pool are executed in the goroutine completing their deps
+)
+
+// PoolStats is the statistics of vertexes submitted to an executor pool.
+type PoolStats struct {
+	Name      string
+	Submitted uint64 // vertexes accepted by the executor
+	Rejected  uint64 // vertexes rejected by the executor
+	Running   int64  // vertexes being executed
+	Completed uint64 // vertexes executed
+}
+
+// executorPool is a named executor, whose executor can be replaced while executing graphs
+type executorPool struct {
+	name     string
+	lock     sync.RWMutex // guards executor
+	executor executor.Executor
+
+	submitted atomic.Uint64
+	rejected  atomic.Uint64
+	running   atomic.Int64
+	completed atomic.Uint64
+
+	unfused bool // submits every vertex instead of fusing the chains in the pool, e.x. to benchmark the fusion
+}
+
+func newExecutorPool(name string, e executor.Executor) *executorPool {
+	return &executorPool{name: name, executor: e}
+}
+
+func (p *executorPool) getExecutor() executor.Executor {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.executor
+}
+
+// replace the executor, and stop the previous one after it processes the tasks submitted to it
+func (p *executorPool) replace(e executor.Executor) {
+	p.lock.Lock()
+	previous := p.executor
+	p.executor = e
+	p.lock.Unlock()
+	if previous != nil {
+		previous.Stop()
+	}
+}
+
+func (p *executorPool) stop() {
+	if e := p.getExecutor(); e != nil {
+		e.Stop()
+	}
+}
+
+func (p *executorPool) isInline() bool {
+	return p.name == InlinePool
+}
+
+// submit a task to the executor, the submission gives up once ctx is done. If the executor is replaced during the
+// submission, the task is submitted to the new one. The priority is used if the executor is a PriorityExecutor, and
+// the task is pushed to the local queue of worker if the executor is a LocalExecutor.
+func (p *executorPool) submit(ctx context.Context, priority executor.Priority, worker int,
+	task func(worker int)) error {
+	if p.isInline() {
+		p.run(func() {
+			task(executor.NoWorker)
+		})
+		return nil
+	}
+	for {
+		e := p.getExecutor()
+		var err error
+		switch te := e.(type) {
+		case executor.LocalExecutor:
+			err = te.SubmitLocal(ctx, worker, func(worker int) {
+				p.run(func() {
+					task(worker)
+				})
+			})
+		case executor.PriorityExecutor:
+			err = te.SubmitWithPriority(ctx, priority, func() {
+				p.run(func() {
+					task(executor.NoWorker)
+				})
+			})
+		default:
+			err = e.Submit(ctx, func() {
+				p.run(func() {
+					task(executor.NoWorker)
+				})
+			})
+		}
+		if errors.Is(err, executor.ErrExecutorStopped) && p.getExecutor() != e {
+			continue
+		}
+		p.count(err)
+		return err
+	}
+}
+
+// trySubmit submits a task without blocking
+func (p *executorPool) trySubmit(task func()) error {
+	if p.isInline() {
+		p.run(task)
+		return nil
+	}
+	err := p.getExecutor().TrySubmit(func() {
+		p.run(task)
+	})
+	p.count(err)
+	return err
+}
+
+func (p *executorPool) run(task func()) {
+	p.running.Inc()
+	task()
+	p.running.Dec()
+	p.completed.Inc()
+}
+
+func (p *executorPool) count(err error) {
+	if err != nil {
+		p.rejected.Inc()
+	} else {
+		p.submitted.Inc()
+	}
+}
+
+func (p *executorPool) stats() PoolStats {
+	return PoolStats{
+		Name:      p.name,
+		Submitted: p.submitted.Load(),
+		Rejected:  p.rejected.Load(),
+		Running:   p.running.Load(),
+		Completed: p.completed.Load(),
+	}
+}
@@ -1,81 +1,61 @@
 package core
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"github.com/MisakiOfScut/go-dage/internal/script"
 	"github.com/MisakiOfScut/go-dage/internal/utils/executor"
 	"github.com/MisakiOfScut/go-dage/internal/utils/log"
 	"go.uber.org/atomic"
+	"sort"
+	"sync"
 	"time"
 )
 
+// graphClusterContext is shared by all graphContexts built from a graph cluster
 type graphClusterContext struct {
-	endTimeStamp int64 // the timestamp when timeout
-	executor     executor.Executor
+	name         string
+	pools        func(name string) *executorPool
+	limiters     func(oprID string) *operatorLimiter
+	breakers     func(oprID string) *circuitBreaker
 	oprMgr       OperatorManager
-	graphCtxMap  map[string]*graphContext
+	interceptors *interceptorRegistry
 }
 
-func newGraphClusterContext(executor executor.Executor, oprMgr OperatorManager) *graphClusterContext {
+func newGraphClusterContext(name string, pools func(name string) *executorPool,
+	limiters func(oprID string) *operatorLimiter, breakers func(oprID string) *circuitBreaker,
+	oprMgr OperatorManager, interceptors *interceptorRegistry) *graphClusterContext {
 	return &graphClusterContext{
-		endTimeStamp: 0,
-		executor:     executor,
+		name:         name,
+		pools:        pools,
+		limiters:     limiters,
+		breakers:     breakers,
 		oprMgr:       oprMgr,
-		graphCtxMap:  make(map[string]*graphContext),
+		interceptors: interceptors,
 	}
 }
 
-func (gc *graphClusterContext) getExecutor() executor.Executor {
-	return gc.executor
+// getPool returns the executor pool by name, nil if it isn't existed
+func (gc *graphClusterContext) getPool(name string) *executorPool {
+	return gc.pools(name)
 }
 
-func (gc *graphClusterContext) getOprMgr() OperatorManager {
-	return gc.oprMgr
-}
-
-func (gc *graphClusterContext) setTimeout(millisecond int64) {
-	gc.endTimeStamp = millisecond + time.Now().UnixMilli()
+// getLimiter returns the limiter of an operator, which is unlimited if the operator isn't limited
+func (gc *graphClusterContext) getLimiter(oprID string) *operatorLimiter {
+	return gc.limiters(oprID)
 }
 
-func (gc *graphClusterContext) getEndTime() int64 {
-	return gc.endTimeStamp
+// getBreaker returns the circuit breaker of an operator, nil if it has no breaker
+func (gc *graphClusterContext) getBreaker(oprID string) *circuitBreaker {
+	return gc.breakers(oprID)
 }
 
-func (gc *graphClusterContext) addGraphCtx(name string, g *graphContext) {
-	gc.graphCtxMap[name] = g
-}
-
-func (gc *graphClusterContext) build(cluster *script.GraphCluster) {
-	for i, _ := range cluster.Graph {
-		gc.addGraphCtx(cluster.Graph[i].Name, newGraphContext(gc))
-	}
-	for name, graphContext := range gc.graphCtxMap {
-		graphContext.build(cluster.GetGraphByName(name))
-	}
-}
-
-func (gc *graphClusterContext) execute(context *DAGContext, graphName string, timeoutMillisecond int64,
-	doneClosure func()) error {
-	if _, ok := gc.graphCtxMap[graphName]; !ok {
-		return fmt.Errorf("graph %s is not existed", graphName)
-	}
-	if timeoutMillisecond > 0 {
-		gc.setTimeout(timeoutMillisecond)
-	}
-
-	gc.graphCtxMap[graphName].execute(context, func() {
-		log.Debugf("%s execution ended in %s", graphName, time.Now().String())
-		doneClosure()
-	})
-
-	return nil
+func (gc *graphClusterContext) getOprMgr() OperatorManager {
+	return gc.oprMgr
 }
 
-func (gc *graphClusterContext) reset() {
-	gc.endTimeStamp = 0
-	for _, graphCtx := range gc.graphCtxMap {
-		graphCtx.reset()
-	}
+func (gc *graphClusterContext) getInterceptors() *interceptorRegistry {
+	return gc.interceptors
 }
 
 type graphContext struct {
@@ -83,24 +63,127 @@ type graphContext struct {
 	remainingVertexes atomic.Uint32
 	vertexCtxMap      map[string]*vertexContext
 	outputDataMap     map[string]*vertexContext
+	timeoutMs         int64 // timeout_ms in script, used if the caller doesn't specify a timeout
+	failFast          bool
+	finallyVertexes   []*vertexContext
+	compensators      []*vertexContext // vertexes having compensate_op, in reverse topological order
+	profile           *graphProfile    // shared by all contexts of the graph
 
 	// runtime assign
-	context     *DAGContext
-	doneClosure func()
+	context      *DAGContext
+	cancel       context.CancelFunc // cancels the context of the execution
+	doneClosure  func(result *ExecutionResult)
+	recycle      func()      // called after all vertexes are done
+	endTimeStamp int64       // the timestamp when timeout
+	done         atomic.Bool // set after doneClosure is called
+	aborted      atomic.Bool
+	timeout      atomic.Bool // set if some vertexes are skipped because of the timeout
+	failed       atomic.Bool
+	errLock      sync.Mutex // guards err, errVertex and buckets
+	err          error      // the error aborting the execution, or the first error of operators
+	errVertex    string     // the vertex returning err
+	buckets      map[string]string
+	// the context of compensating operators and finally vertexes, which isn't canceled by the timeout or aborting
+	finallyContext *DAGContext
+	inFinally      bool // set when finally vertexes start
+	priorityClass  int
+	compensations  []CompensationResult
 
 	graphClusterCtx *graphClusterContext
 }
 
-func newGraphContext(ctx *graphClusterContext) *graphContext {
+func newGraphContext(ctx *graphClusterContext, profile *graphProfile) *graphContext {
 	return &graphContext{
 		graphClusterCtx: ctx,
+		profile:         profile,
 		vertexCtxMap:    make(map[string]*vertexContext),
 		outputDataMap:   make(map[string]*vertexContext),
 	}
 }
 
 func (g *graphContext) getEndTime() int64 {
-	return g.graphClusterCtx.getEndTime()
+	return g.endTimeStamp
+}
+
+func (g *graphContext) isTimeout() bool {
+	return g.endTimeStamp != 0 && g.endTimeStamp <= time.Now().UnixMilli()
+}
+
+// isCanceled reports whether the execution is timeout, or its parent context is done
+func (g *graphContext) isCanceled() bool {
+	return g.isTimeout() || g.context.Context().Err() != nil
+}
+
+// onOperatorFailed records the error, and aborts the execution if the graph is fail fast, the vertex is critical or
+// the operator returns ErrAbortGraph
+func (g *graphContext) onOperatorFailed(v *vertexContext, err error) {
+	if g.failed.CAS(false, true) {
+		g.setErr(v.id, err)
+	}
+	if !v.finally && (g.failFast || v.critical || errors.Is(err, ErrAbortGraph)) {
+		g.abort(v.id, err)
+	}
+}
+
+// abort cancels the running operators, skips the pending vertexes and calls doneClosure immediately
+func (g *graphContext) abort(vertexID string, err error) {
+	if !g.aborted.CAS(false, true) {
+		return
+	}
+	log.Infof("graph:%s execution is aborted by vertex:%s with err:%v", g.name, vertexID, err)
+	g.setErr(vertexID, err)
+	g.cancel()
+	if len(g.compensators) == 0 {
+		// otherwise doneClosure is called after compensating, so that the result contains the compensations
+		g.finish()
+	}
+}
+
+func (g *graphContext) setErr(vertexID string, err error) {
+	g.errLock.Lock()
+	defer g.errLock.Unlock()
+	g.err, g.errVertex = err, vertexID
+}
+
+// setBucket records the bucket assigned by a split vertex
+func (g *graphContext) setBucket(vertexID string, bucket string) {
+	g.errLock.Lock()
+	defer g.errLock.Unlock()
+	if g.buckets == nil {
+		g.buckets = make(map[string]string)
+	}
+	g.buckets[vertexID] = bucket
+}
+
+// finish calls doneClosure once
+func (g *graphContext) finish() {
+	if !g.done.CAS(false, true) {
+		return
+	}
+	log.Debugf("%s execution ended in %s", g.name, time.Now().String())
+	result := &ExecutionResult{Status: g.getStatus(), Compensations: g.compensations}
+	if result.Status == ExecutionTimeout {
+		result.Err = context.DeadlineExceeded
+	} else {
+		g.errLock.Lock()
+		result.Err, result.Vertex = g.err, g.errVertex
+		g.errLock.Unlock()
+	}
+	g.errLock.Lock()
+	result.Buckets = g.buckets
+	g.errLock.Unlock()
+	g.doneClosure(result)
+}
+
+func (g *graphContext) getStatus() ExecutionStatus {
+	if g.aborted.Load() {
+		return ExecutionAborted
+	} else if g.timeout.Load() {
+		return ExecutionTimeout
+	} else if g.failed.Load() {
+		return ExecutionFailed
+	}
+	return ExecutionOk
 }
 
 func (g *graphContext) getOprMgr() OperatorManager {
@@ -115,7 +198,10 @@ func (g *graphContext) getVertexCtxByData(dataID string) *vertexContext {
 	return g.outputDataMap[dataID]
 }
 
-func (g *graphContext) build(graph *script.Graph) {
+func (g *graphContext) build(graph *script.Graph) error {
+	g.name = graph.Name
+	g.timeoutMs = graph.TimeoutMs
+	g.failFast = graph.FailFast
 	for i, _ := range graph.Vertex {
 		g.vertexCtxMap[graph.Vertex[i].ID] = newVertexContext(g)
 	}
@@ -123,52 +209,326 @@ func (g *graphContext) build(graph *script.Graph) {
 		g.outputDataMap[id] = g.getVertexCtx(vertex.ID)
 	}
 	for id, vertexContext := range g.vertexCtxMap {
-		vertexContext.build(graph.GetVertexByID(id))
+		if err := vertexContext.build(graph.GetVertexByID(id)); err != nil {
+			return err
+		}
+		if vertexContext.finally {
+			g.finallyVertexes = append(g.finallyVertexes, vertexContext)
+		}
+	}
+	order := g.topologicalOrder()
+	for i := len(order) - 1; i >= 0; i-- {
+		if order[i].compensator != nil {
+			g.compensators = append(g.compensators, order[i])
+		}
+	}
+	g.remainingVertexes.Store(uint32(len(g.vertexCtxMap) - len(g.finallyVertexes)))
+	return nil
+}
+
+// topologicalOrder returns all vertexes in topological order, vertexes in the same level are sorted by id
+func (g *graphContext) topologicalOrder() []*vertexContext {
+	inDegree := make(map[*vertexContext]int, len(g.vertexCtxMap))
+	var ready []*vertexContext
+	for _, v := range g.vertexCtxMap {
+		if inDegree[v] = len(v.depsIdx); inDegree[v] == 0 {
+			ready = append(ready, v)
+		}
+	}
+	order := make([]*vertexContext, 0, len(g.vertexCtxMap))
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return ready[i].id < ready[j].id })
+		var next []*vertexContext
+		for _, v := range ready {
+			order = append(order, v)
+			for _, n := range v.nextVertexCtx {
+				if inDegree[n]--; inDegree[n] == 0 {
+					next = append(next, n)
+				}
+			}
+		}
+		ready = next
+	}
+	return order
+}
+
+func (g *graphContext) warmup() error {
+	for _, vertexCtx := range g.vertexCtxMap {
+		if err := vertexCtx.warmup(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *graphContext) close() {
+	for _, vertexCtx := range g.vertexCtxMap {
+		vertexCtx.close()
 	}
-	g.remainingVertexes.Store(uint32(len(g.vertexCtxMap)))
-	g.name = graph.Name
 }
 
-func (g *graphContext) execute(context *DAGContext, doneClosure func()) {
-	g.context = context
+// execute the graph, the execution is timeout after timeoutMillisecond or the deadline of parent, whichever comes
+// first. Non-positive timeoutMillisecond means using the timeout_ms of the graph.
+// doneClosure is called when the execution ends or is aborted, and recycle is called after all vertexes are done.
+func (g *graphContext) execute(parent context.Context, dagCtx *DAGContext, timeoutMillisecond int64,
+	doneClosure func(result *ExecutionResult), recycle func()) {
+	g.prepare(parent, dagCtx, timeoutMillisecond, doneClosure, recycle)
+	_ = g.start()
+}
+
+// prepare the execution without dispatching any vertex, the execution can be aborted after it returns
+func (g *graphContext) prepare(parent context.Context, dagCtx *DAGContext, timeoutMillisecond int64,
+	doneClosure func(result *ExecutionResult), recycle func()) {
+	if timeoutMillisecond <= 0 {
+		timeoutMillisecond = g.timeoutMs
+	}
+	if timeoutMillisecond > 0 {
+		g.endTimeStamp = time.Now().UnixMilli() + timeoutMillisecond
+	}
+	if parent == nil {
+		parent = context.Background()
+	}
+	if deadline, ok := parent.Deadline(); ok && (g.endTimeStamp == 0 || deadline.UnixMilli() < g.endTimeStamp) {
+		g.endTimeStamp = deadline.UnixMilli()
+	}
+	if g.endTimeStamp != 0 {
+		dagCtx.ctx, g.cancel = context.WithDeadline(parent, time.UnixMilli(g.endTimeStamp))
+	} else {
+		dagCtx.ctx, g.cancel = context.WithCancel(parent)
+	}
+
+	g.context = dagCtx
+	g.priorityClass = getPriorityClass(parent)
 	g.doneClosure = doneClosure
+	g.recycle = recycle
+}
 
+// start the prepared execution by dispatching its ready vertexes. If a start vertex is rejected by its executor, the
+// execution is aborted and the rejection is returned, doneClosure isn't called in this case.
+func (g *graphContext) start() error {
 	var readyVertex []*vertexContext
 	for _, vertexCtx := range g.vertexCtxMap {
-		if vertexCtx.isReady() {
+		if vertexCtx.isReady() && !vertexCtx.finally {
 			readyVertex = append(readyVertex, vertexCtx)
 		}
 	}
-	g.executeReadyVertex(readyVertex)
+	if len(readyVertex) == 0 {
+		// the graph only has finally vertexes, or it is empty
+		g.onVertexesDone()
+		return nil
+	}
+	// the rejection is recorded in the caller's goroutine, since the context may be recycled when dispatch returns
+	var rejected error
+	caller := runner{worker: executor.NoWorker, rejected: &rejected}
+	g.executeInline(g.dispatch(readyVertex, nil, caller), noRunner)
+	return rejected
+}
+
+// onVertexesDone is called after all vertexes except finally vertexes are done, it executes the compensating
+// operators if the execution isn't ok, and then executes finally vertexes
+func (g *graphContext) onVertexesDone() {
+	g.inFinally = true
+	// the execution is timeout once its deadline passed, even if no vertex is skipped after the deadline
+	if g.isTimeout() || errors.Is(g.context.Context().Err(), context.DeadlineExceeded) {
+		g.timeout.Store(true)
+	}
+	g.cancel()
+	compensate := len(g.compensators) > 0 && g.getStatus() != ExecutionOk
+	if !compensate && len(g.finallyVertexes) == 0 {
+		g.end()
+		return
+	}
+
+	ctx := *g.context
+	ctx.ctx = nil
+	ctx.vertexResults = make(map[string]VertexResult, len(g.vertexCtxMap)-len(g.finallyVertexes))
+	for id, v := range g.vertexCtxMap {
+		if !v.finally {
+			ctx.vertexResults[id] = v.getResult()
+		}
+	}
+	g.finallyContext = &ctx
+	if compensate {
+		g.compensate()
+	}
+	if len(g.finallyVertexes) == 0 {
+		g.end()
+		return
+	}
+	g.remainingVertexes.Store(uint32(len(g.finallyVertexes)))
+	g.executeReadyVertex(g.finallyVertexes)
+}
+
+// compensate executes the compensating operators of succeeded vertexes one by one
+func (g *graphContext) compensate() {
+	for _, v := range g.compensators {
+		if v.getResult() != VertexOk {
+			continue
+		}
+		err := v.compensate(g.finallyContext)
+		if err != nil {
+			log.Errorf("graph:%s, vertex:%s, with compensate_op:%s, execution return err:%v", g.name, v.id,
+				v.compensateOprID, err)
+		}
+		g.compensations = append(g.compensations, CompensationResult{Vertex: v.id, Operator: v.compensateOprID,
+			Err: err})
+	}
+}
+
+// runner is the goroutine executing vertexes, which is a worker of the executor of pool. pool is nil if the goroutine
+// doesn't belong to any pool, and worker is executor.NoWorker if the executor isn't a LocalExecutor.
+type runner struct {
+	pool     *executorPool
+	worker   int
+	rejected *error // set to the rejection of start vertexes, only in the goroutine calling Execute
 }
 
+// noRunner is the goroutines not belonging to any pool, such as the ones calling Execute
+var noRunner = runner{worker: executor.NoWorker}
+
+// executeReadyVertex submits vertexes to their pools, and executes the inline vertexes in the current goroutine
 func (g *graphContext) executeReadyVertex(vertexes []*vertexContext) {
+	g.executeInline(g.dispatch(vertexes, nil, noRunner), noRunner)
+}
+
+// dispatch submits ready vertexes to their pools, and appends the vertexes which should be executed in the current
+// goroutine to inline. They are the vertexes marked inline, and the last vertex in the pool of the current goroutine,
+// so that a linear chain of vertexes in a pool is executed in one goroutine without any hop.
+func (g *graphContext) dispatch(vertexes []*vertexContext, inline []*vertexContext,
+	current runner) []*vertexContext {
+	var last *vertexContext
 	for _, v := range vertexes {
-		vCatch := v
-		g.graphClusterCtx.getExecutor().Execute(func() {
-			vCatch.execute()
-		})
+		if v.inline {
+			inline = append(inline, v)
+			continue
+		}
+		if current.pool != nil && v.pool == current.pool && !v.pool.unfused && !v.finally {
+			if last != nil {
+				g.submit(last, current)
+			}
+			last = v
+			continue
+		}
+		g.submit(v, current)
 	}
+	if last != nil {
+		inline = append(inline, last)
+	}
+	return inline
 }
 
-func (g *graphContext) onVertexDone(v *vertexContext) {
-	if g.remainingVertexes.Sub(1) == 0 {
-		g.doneClosure()
+// submit a vertex to its pool, which prefers the local queue of the current worker if it's in the same pool
+func (g *graphContext) submit(v *vertexContext, current runner) {
+	task := func(worker int) {
+		v.execute()
+		r := runner{pool: v.pool, worker: worker}
+		g.executeInline(g.onVertexDone(v, nil, r), r)
+	}
+	if v.finally {
+		// finally vertexes always run, in the current goroutine if the executor is saturated or stopped
+		if v.pool.trySubmit(func() { task(executor.NoWorker) }) != nil {
+			task(executor.NoWorker)
+		}
 		return
 	}
+	worker := executor.NoWorker
+	if v.pool == current.pool {
+		worker = current.worker
+	}
+	// the submission gives up once the execution is timeout or aborted, so a saturated executor can't hang it
+	if err := v.pool.submit(g.context.Context(), v.priority(), worker, task); err != nil {
+		g.onSubmitFailed(v, err, current)
+	}
+}
 
-	var readyVertex []*vertexContext
-	for i, _ := range v.nextVertexCtx {
-		if v.nextVertexCtx[i].setDependencyRes(v.id, v.result) == 0 {
-			readyVertex = append(readyVertex, v.nextVertexCtx[i])
+// executeInline executes vertexes in the current goroutine, along with the vertexes becoming ready which should be
+// executed inline, in a loop instead of recursion
+func (g *graphContext) executeInline(inline []*vertexContext, current runner) {
+	for len(inline) > 0 {
+		v := inline[len(inline)-1]
+		inline = inline[:len(inline)-1]
+		v.pool.run(v.execute)
+		inline = g.onVertexDone(v, inline, current)
+	}
+}
+
+// onSubmitFailed skips a vertex rejected by the executor, and aborts the execution with the rejection. The rejection
+// of a start vertex is returned to the caller of Execute, unless doneClosure has been called.
+func (g *graphContext) onSubmitFailed(v *vertexContext, err error, current runner) {
+	log.Warnf("graph:%s, submitting vertex:%s failed with err:%v", g.name, v.id, err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		g.timeout.Store(true)
+	} else {
+		if current.rejected != nil && *current.rejected == nil && g.done.CAS(false, true) {
+			*current.rejected = err
 		}
+		g.abort(v.id, err)
 	}
-	g.executeReadyVertex(readyVertex)
+	v.skip()
+	g.executeInline(g.onVertexDone(v, nil, noRunner), noRunner)
+}
+
+// onVertexDone updates the deps of the next vertexes of a done vertex, dispatches the ready ones and returns the
+// ones which should be executed inline with inline
+func (g *graphContext) onVertexDone(v *vertexContext, inline []*vertexContext,
+	current runner) []*vertexContext {
+	// vertexes done without being executed, they are skipped inline after the execution is aborted
+	doneVertexes := []*vertexContext{v}
+	for len(doneVertexes) > 0 {
+		v = doneVertexes[len(doneVertexes)-1]
+		doneVertexes = doneVertexes[:len(doneVertexes)-1]
+		if g.remainingVertexes.Sub(1) == 0 {
+			if !g.inFinally {
+				g.onVertexesDone()
+			} else {
+				g.end()
+			}
+			return inline
+		}
+
+		var readyVertex []*vertexContext
+		for i, _ := range v.nextVertexCtx {
+			next := v.nextVertexCtx[i]
+			if next.setDependencyRes(v.id, v.result) != 0 {
+				continue
+			}
+			if g.aborted.Load() {
+				next.skip()
+				doneVertexes = append(doneVertexes, next)
+			} else {
+				readyVertex = append(readyVertex, next)
+			}
+		}
+		inline = g.dispatch(readyVertex, inline, current)
+	}
+	return inline
+}
+
+// end the execution after all vertexes are done
+func (g *graphContext) end() {
+	g.profile.onExecutionDone()
+	g.cancel()
+	g.finish()
+	g.recycle()
 }
 
 func (g *graphContext) reset() {
 	g.context = nil
-	g.remainingVertexes.Store(uint32(len(g.vertexCtxMap)))
+	g.cancel = nil
+	g.doneClosure = nil
+	g.recycle = nil
+	g.endTimeStamp = 0
+	g.done.Store(false)
+	g.aborted.Store(false)
+	g.timeout.Store(false)
+	g.failed.Store(false)
+	g.err, g.errVertex = nil, ""
+	g.buckets = nil // owned by the result of the previous execution
+	g.finallyContext = nil
+	g.inFinally = false
+	g.priorityClass = PriorityNormal
+	g.compensations = nil
+	g.remainingVertexes.Store(uint32(len(g.vertexCtxMap) - len(g.finallyVertexes)))
 	for _, vertexContext := range g.vertexCtxMap {
 		vertexContext.reset()
 	}
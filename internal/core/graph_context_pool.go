@@ -0,0 +1,85 @@
+package core
+
+import (
+	"go.uber.org/atomic"
+)
+
+const defaultMaxIdleGraphContext = 1024
+
+// graphContextPool caches built graphContexts of a graph. Unlike sync.Pool, it closes the operators of every
+// context it discards, so operators holding resources won't leak.
+type graphContextPool struct {
+	newFunc func() (*graphContext, error)
+	idle    chan *graphContext
+	closed  atomic.Bool
+}
+
+func newGraphContextPool(maxIdle int,
+	newFunc func() (*graphContext, error)) *graphContextPool {
+	return &graphContextPool{newFunc: newFunc, idle: make(chan *graphContext, maxIdle)}
+}
+
+// get an idle context or build a new one
+func (p *graphContextPool) get() (*graphContext, error) {
+	select {
+	case gc := <-p.idle:
+		return gc, nil
+	default:
+		return p.newFunc()
+	}
+}
+
+// put a context back to pool, the context will be closed if the pool is full or closed
+func (p *graphContextPool) put(gc *graphContext) {
+	if p.closed.Load() {
+		gc.close()
+		return
+	}
+	select {
+	case p.idle <- gc:
+		// close() may have drained the pool between the check and the sending
+		if p.closed.Load() {
+			p.drain()
+		}
+	default:
+		gc.close()
+	}
+}
+
+// warmup makes sure there are at least n idle contexts, and calls Warmup of every operator in them
+func (p *graphContextPool) warmup(n int) error {
+	contexts := make([]*graphContext, 0, n)
+	defer func() {
+		for _, gc := range contexts {
+			p.put(gc)
+		}
+	}()
+	for i := 0; i < n; i++ {
+		gc, err := p.get()
+		if err != nil {
+			return err
+		}
+		contexts = append(contexts, gc)
+		if err = gc.warmup(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close the pool and all idle contexts, the contexts in use will be closed when they are put back
+func (p *graphContextPool) close() {
+	p.closed.Store(true)
+	p.drain()
+}
+
+func (p *graphContextPool) drain() {
+	for {
+		select {
+		case gc := <-p.idle:
+			gc.close()
+		default:
+			return
+		}
+	}
+}
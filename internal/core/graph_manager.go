@@ -1,11 +1,14 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/MisakiOfScut/go-dage/internal/script"
 	"github.com/MisakiOfScut/go-dage/internal/utils/executor"
 	"github.com/MisakiOfScut/go-dage/internal/utils/log"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -13,44 +16,90 @@ import (
 type graphExecutor struct {
 	name          string
 	graphClusters *script.GraphCluster
-	// graphClusterContextPool executor.ConcurrentQueue
-	graphClusterContextPool *sync.Pool
+	pools         map[string]*graphContextPool // map graph name to the pool of its contexts
+	executions    *executionRegistry
 }
 
-func (g *graphExecutor) execute(context *DAGContext, graphName string, timeoutMillisecond int64,
-	usersDoneClosure func()) error {
-	gc, ok := g.graphClusterContextPool.Get().(*graphClusterContext)
+func (g *graphExecutor) execute(parent context.Context, dagCtx *DAGContext, graphName string,
+	timeoutMillisecond int64, usersDoneClosure func(result *ExecutionResult)) error {
+	pool, ok := g.pools[graphName]
 	if !ok {
-		log.Panicf("assert from graphClusterContextPool.Get failed")
+		return fmt.Errorf("graph %s is not existed", graphName)
 	}
-	return gc.execute(context, graphName, timeoutMillisecond, func() {
-		gc.reset()
-		g.graphClusterContextPool.Put(gc)
+	gc, err := pool.get()
+	if err != nil {
+		return err
+	}
+	gc.prepare(parent, dagCtx, timeoutMillisecond, func(result *ExecutionResult) {
 		if usersDoneClosure != nil {
-			usersDoneClosure()
+			usersDoneClosure(result)
 		}
+	}, func() {
+		g.executions.remove(gc, func() {
+			gc.reset()
+			pool.put(gc)
+		})
 	})
+	// registered after being prepared, so that shutting down can abort it before any vertex is dispatched
+	if err = g.executions.add(gc, g.name, graphName); err != nil {
+		gc.cancel()
+		gc.reset()
+		pool.put(gc)
+		return err
+	}
+	return gc.start()
+}
+
+func (g *graphExecutor) warmup(n int) error {
+	for _, pool := range g.pools {
+		if err := pool.warmup(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *graphExecutor) close() {
+	for _, pool := range g.pools {
+		pool.close()
+	}
 }
 
 type GraphManager struct {
 	graphExecutors map[string]*graphExecutor
 	lock           sync.RWMutex
-	taskExecutor   executor.Executor
+	poolLock       sync.RWMutex // guards pools
+	pools          map[string]*executorPool
 	oprMgr         OperatorManager
+	interceptors   *interceptorRegistry
+	executions     *executionRegistry
+	limiters       *limiterRegistry
+	breakers       *breakerRegistry
 }
 
 func NewGraphManager(executor executor.Executor, oprMgr OperatorManager) *GraphManager {
-	return &GraphManager{
+	m := &GraphManager{
 		graphExecutors: make(map[string]*graphExecutor),
 		lock:           sync.RWMutex{},
-		taskExecutor:   executor,
 		oprMgr:         oprMgr,
+		executions:     newExecutionRegistry(),
+		limiters:       newLimiterRegistry(),
+		breakers:       newBreakerRegistry(),
+		pools: map[string]*executorPool{
+			DefaultPool: newExecutorPool(DefaultPool, executor),
+			InlinePool:  newExecutorPool(InlinePool, nil),
+		},
 	}
+	m.interceptors = newInterceptorRegistry(m.resolveOperatorID)
+	return m
 }
 
 func (m *GraphManager) setGraphExecutor(ge *graphExecutor) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
+	if previous, ok := m.graphExecutors[ge.name]; ok {
+		previous.close()
+	}
 	m.graphExecutors[ge.name] = ge
 }
 
@@ -63,16 +112,61 @@ func (m *GraphManager) getGraphExecutor(name string) *graphExecutor {
 	return nil
 }
 
+// probeOperator creates an operator object to read what it declares, and closes the object after f returns. f isn't
+// called if the operator isn't existed.
+func (m *GraphManager) probeOperator(oprName string, f func(opr Operator)) bool {
+	opr := m.oprMgr.GetOperator(oprName)
+	if opr == nil {
+		return false
+	}
+	defer closeOperator(opr)
+	f(opr)
+	return true
+}
+
 func (m *GraphManager) IsOprExisted(oprName string) bool {
-	return m.oprMgr.GetOperator(oprName) != nil
+	return m.probeOperator(oprName, func(opr Operator) {})
+}
+
+func (m *GraphManager) GetOperatorInputs(oprName string) (inputs []string) {
+	m.probeOperator(oprName, func(opr Operator) {
+		inputs = opr.GetInputsID()
+	})
+	return inputs
 }
 
-func (m *GraphManager) GetOperatorInputs(oprName string) []string {
-	return m.oprMgr.GetOperator(oprName).GetInputsID()
+func (m *GraphManager) GetOperatorOutputs(oprName string) (outputs []string) {
+	m.probeOperator(oprName, func(opr Operator) {
+		outputs = opr.GetOutputsID()
+	})
+	return outputs
 }
 
-func (m *GraphManager) GetOperatorOutputs(oprName string) []string {
-	return m.oprMgr.GetOperator(oprName).GetOutputsID()
+func (m *GraphManager) GetOperatorInputTypes(oprName string) (types map[string]reflect.Type) {
+	m.probeOperator(oprName, func(opr Operator) {
+		if typed, ok := opr.(TypedDataOperator); ok {
+			types = typed.GetInputsType()
+		}
+	})
+	return types
+}
+
+func (m *GraphManager) GetOperatorOutputTypes(oprName string) (types map[string]reflect.Type) {
+	m.probeOperator(oprName, func(opr Operator) {
+		if typed, ok := opr.(TypedDataOperator); ok {
+			types = typed.GetOutputsType()
+		}
+	})
+	return types
+}
+
+func (m *GraphManager) GetOperatorOutcomes(oprName string) (outcomes []string) {
+	m.probeOperator(oprName, func(opr Operator) {
+		if declarer, ok := opr.(OutcomeDeclarer); ok {
+			outcomes = declarer.GetOutcomes()
+		}
+	})
+	return outcomes
 }
 
 func (m *GraphManager) IsProduction() bool {
@@ -81,12 +175,35 @@ func (m *GraphManager) IsProduction() bool {
 
 func (m *GraphManager) Execute(userData interface{}, graphClusterName string, graphName string,
 	timeoutMillisecond int64, usersDoneClosure func()) error {
+	return m.ExecuteContext(nil, userData, graphClusterName, graphName, timeoutMillisecond, usersDoneClosure)
+}
+
+// ExecuteContext executes a graph as a child of ctx, the execution is timeout at the deadline of ctx if it comes
+// before the timeout of the execution, and the execution is aborted if ctx is canceled.
+func (m *GraphManager) ExecuteContext(ctx context.Context, userData interface{}, graphClusterName string,
+	graphName string, timeoutMillisecond int64, usersDoneClosure func()) error {
+	var done func(result *ExecutionResult)
+	if usersDoneClosure != nil {
+		done = func(result *ExecutionResult) {
+			usersDoneClosure()
+		}
+	}
+	return m.ExecuteWithResult(ctx, userData, graphClusterName, graphName, timeoutMillisecond, done)
+}
+
+// ExecuteWithResult executes a graph like ExecuteContext, and passes the result of the execution to
+// usersDoneClosure. If the execution is aborted, usersDoneClosure is called immediately without waiting for the
+// running operators.
+// If a start vertex is rejected by its executor, the execution is aborted and the rejection is returned without
+// calling usersDoneClosure.
+func (m *GraphManager) ExecuteWithResult(ctx context.Context, userData interface{}, graphClusterName string,
+	graphName string, timeoutMillisecond int64, usersDoneClosure func(result *ExecutionResult)) error {
 	g := m.getGraphExecutor(graphClusterName)
 	if g == nil {
 		return fmt.Errorf("graphCluster:%s is not existed", graphClusterName)
 	}
-	return g.execute(&DAGContext{dagParams: newDagParams(), UserData: userData}, graphName, timeoutMillisecond,
-		usersDoneClosure)
+	return g.execute(ctx, &DAGContext{dagParams: newDagParams(), UserData: userData}, graphName,
+		timeoutMillisecond, usersDoneClosure)
 }
 
 func (m *GraphManager) Build(clusterName string, tomlScript *string) error {
@@ -101,22 +218,104 @@ func (m *GraphManager) Build(clusterName string, tomlScript *string) error {
 		return err
 	}
 
-	ge := &graphExecutor{
-		name: clusterName, graphClusters: graphCluster, graphClusterContextPool: &sync.Pool{
-			New: func() interface{} {
-				graphClusterCtx := newGraphClusterContext(m.taskExecutor, m.oprMgr)
-				graphClusterCtx.build(graphCluster)
-				return graphClusterCtx
-			},
-		},
+	clusterCtx := newGraphClusterContext(clusterName, m.getExecutorPool, m.getLimiter, m.getBreaker, m.oprMgr,
+		m.interceptors)
+	ge := &graphExecutor{name: clusterName, graphClusters: graphCluster, pools: make(map[string]*graphContextPool),
+		executions: m.executions}
+	for i, _ := range graphCluster.Graph {
+		graph := &graphCluster.Graph[i]
+		profile := newGraphProfile(graph)
+		pool := newGraphContextPool(defaultMaxIdleGraphContext, func() (*graphContext, error) {
+			graphCtx := newGraphContext(clusterCtx, profile)
+			if err := graphCtx.build(graph); err != nil {
+				graphCtx.close()
+				return nil, err
+			}
+			return graphCtx, nil
+		})
+		ge.pools[graph.Name] = pool
+		// build a context in advance, so that operators' init errors are reported by Build
+		gc, err := pool.get()
+		if err != nil {
+			ge.close()
+			log.Errorf("build dag:%s failed, %v", clusterName, err)
+			return err
+		}
+		pool.put(gc)
+	}
+	// the limits are shared by all graph clusters, they are set after the graph cluster is built successfully, and
+	// replace the limits set by the previous script of the cluster
+	limits := make(map[string]OperatorLimit, len(graphCluster.Limit))
+	for i, _ := range graphCluster.Limit {
+		oprID, limit := m.resolveOperatorID(graphCluster.Limit[i].Operator), scriptLimit(&graphCluster.Limit[i])
+		if previous, ok := limits[oprID]; ok && previous != limit {
+			ge.close()
+			return fmt.Errorf("build dag:%s failed, operator:%s is limited twice", clusterName, oprID)
+		}
+		limits[oprID] = limit
+	}
+	if err := m.limiters.setClusterLimits(clusterName, limits); err != nil {
+		ge.close()
+		log.Errorf("build dag:%s failed, %v", clusterName, err)
+		return err
 	}
 	m.setGraphExecutor(ge)
 
 	return nil
 }
 
+// Warmup prepares n contexts for every graph of the graph cluster, and calls Warmup of every operator in them.
+func (m *GraphManager) Warmup(clusterName string, n int) error {
+	g := m.getGraphExecutor(clusterName)
+	if g == nil {
+		return fmt.Errorf("graphCluster:%s is not existed", clusterName)
+	}
+	return g.warmup(n)
+}
+
+// Use adds interceptors wrapping the executions of all operators.
+func (m *GraphManager) Use(interceptors ...Interceptor) {
+	m.interceptors.use(interceptors...)
+}
+
+// UseForCluster adds interceptors wrapping the executions of operators in a graph cluster.
+func (m *GraphManager) UseForCluster(clusterName string, interceptors ...Interceptor) {
+	m.interceptors.useForCluster(clusterName, interceptors...)
+}
+
+// UseForOperator adds interceptors wrapping the executions of an operator, which is specified by its id or alias.
+// They wrap the vertexes referring to the operator by any of its ids and aliases, e.x. the interceptors of "score"
+// wrap the vertexes of "score@1.0" if it's the highest version of "score".
+func (m *GraphManager) UseForOperator(oprID string, interceptors ...Interceptor) {
+	m.interceptors.useForOperator(oprID, interceptors...)
+}
+
 func (m *GraphManager) Stop() {
-	m.taskExecutor.Stop()
+	m.poolLock.RLock()
+	for _, pool := range m.pools {
+		pool.stop()
+	}
+	m.poolLock.RUnlock()
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, ge := range m.graphExecutors {
+		ge.close()
+	}
+}
+
+// Shutdown stops accepting executions, and waits for the running executions until ctx is done. The executions
+// still running then are aborted with ErrShutdown and returned along with the error of ctx. At last, it stops the
+// executor and closes the operators like Stop, which waits for the running operators to return.
+func (m *GraphManager) Shutdown(ctx context.Context) ([]ExecutionInfo, error) {
+	m.executions.close()
+	var cutShort []ExecutionInfo
+	err := m.executions.wait(ctx)
+	if err != nil {
+		cutShort = m.executions.abortAll(ErrShutdown)
+		log.Warnf("shutdown with err:%v, %d executions are aborted", err, len(cutShort))
+	}
+	m.Stop()
+	return cutShort, err
 }
 
 func (m *GraphManager) DumpDAGDot(graphClusterName string) string {
@@ -129,7 +328,145 @@ func (m *GraphManager) DumpDAGDot(graphClusterName string) string {
 	return sb.String()
 }
 
+// getExecutorPool returns the executor pool by name, the default pool if name is empty, nil if it isn't existed
+func (m *GraphManager) getExecutorPool(name string) *executorPool {
+	if len(name) == 0 {
+		name = DefaultPool
+	}
+	m.poolLock.RLock()
+	defer m.poolLock.RUnlock()
+	return m.pools[name]
+}
+
+// ReplaceTaskExecutor makes all graph contexts submit vertexes to executor2, and then stops the previous executor
+// after it processes the vertexes already submitted to it. Running executions go on with executor2.
 func (m *GraphManager) ReplaceTaskExecutor(executor2 executor.Executor) {
-	m.taskExecutor.Stop()
-	m.taskExecutor = executor2
+	m.getExecutorPool(DefaultPool).replace(executor2)
+}
+
+// RegisterExecutor registers an executor pool, which is selected by the pool setting of graphs or vertexes in
+// scripts. Pools should be registered before building the scripts using them. Registering an existing pool replaces
+// its executor like ReplaceTaskExecutor.
+func (m *GraphManager) RegisterExecutor(name string, e executor.Executor) error {
+	if len(name) == 0 || name == InlinePool {
+		return fmt.Errorf("pool name:%q is reserved", name)
+	}
+	if e == nil {
+		return fmt.Errorf("executor of pool:%s is nil", name)
+	}
+	m.poolLock.Lock()
+	pool, ok := m.pools[name]
+	if !ok {
+		m.pools[name] = newExecutorPool(name, e)
+	}
+	m.poolLock.Unlock()
+	if ok {
+		pool.replace(e)
+	}
+	return nil
+}
+
+// ResizeExecutor sets the number of workers of the executor of a pool, whose executor should be resizable.
+func (m *GraphManager) ResizeExecutor(pool string, n uint) error {
+	e, err := m.getResizableExecutor(pool)
+	if err != nil {
+		return err
+	}
+	return e.Resize(n)
+}
+
+// ExecutorStats returns the runtime statistics of the executor of a pool, whose executor should be resizable.
+func (m *GraphManager) ExecutorStats(pool string) (executor.Stats, error) {
+	e, err := m.getResizableExecutor(pool)
+	if err != nil {
+		return executor.Stats{}, err
+	}
+	return e.Stats(), nil
+}
+
+func (m *GraphManager) getResizableExecutor(pool string) (executor.ResizableExecutor, error) {
+	p := m.getExecutorPool(pool)
+	if p == nil || p.isInline() {
+		return nil, fmt.Errorf("pool:%q doesn't exist", pool)
+	}
+	e, ok := p.getExecutor().(executor.ResizableExecutor)
+	if !ok {
+		return nil, fmt.Errorf("executor of pool:%s isn't resizable", p.name)
+	}
+	return e, nil
+}
+
+// metaOperatorManager is an OperatorManager providing the metadata of operators, e.x. the default one
+type metaOperatorManager interface {
+	getMeta(oprID string) (OperatorMeta, bool)
+}
+
+func (m *GraphManager) getOperatorMeta(oprID string) (OperatorMeta, bool) {
+	if mgr, ok := m.oprMgr.(metaOperatorManager); ok {
+		return mgr.getMeta(oprID)
+	}
+	return OperatorMeta{}, false
+}
+
+// resolveOperatorID returns the registered id of an operator id or alias, so that they share the same limiter
+func (m *GraphManager) resolveOperatorID(oprID string) string {
+	if meta, ok := m.getOperatorMeta(oprID); ok {
+		return meta.ID()
+	}
+	return oprID
+}
+
+// getLimiter returns the limiter of an operator, which is shared by the vertexes of the operator in all graph
+// clusters, so that the limits set by later scripts take effect on them. The limiter is unlimited if the operator
+// isn't limited by scripts or its registration, and the limit set by scripts takes precedence over the registered
+// one until the graph clusters of the scripts are replaced by the ones not limiting it.
+func (m *GraphManager) getLimiter(oprID string) *operatorLimiter {
+	meta, ok := m.getOperatorMeta(oprID)
+	id := oprID
+	if ok {
+		id = meta.ID()
+	}
+	l := m.limiters.getOrCreate(id)
+	l.setRegistered(meta.Limit)
+	return l
+}
+
+// getBreaker returns the circuit breaker of an operator, nil if the operator is registered without breaker
+func (m *GraphManager) getBreaker(oprID string) *circuitBreaker {
+	meta, ok := m.getOperatorMeta(oprID)
+	if !ok || meta.Breaker == nil {
+		return nil
+	}
+	b := m.breakers.getOrCreate(meta.ID())
+	b.configure(*meta.Breaker)
+	return b
+}
+
+// OnBreakerStateChange adds a hook called after the state of a circuit breaker changed.
+func (m *GraphManager) OnBreakerStateChange(hook BreakerHook) {
+	m.breakers.addHook(hook)
+}
+
+// BreakerStats returns the states of all circuit breakers, sorted by operator id.
+func (m *GraphManager) BreakerStats() []BreakerStats {
+	return m.breakers.stats()
+}
+
+// LimiterStats returns the usage of the limits of all limited operators, sorted by operator id.
+func (m *GraphManager) LimiterStats() []LimiterStats {
+	return m.limiters.stats()
+}
+
+// PoolStats returns the statistics of all executor pools, sorted by name.
+func (m *GraphManager) PoolStats() []PoolStats {
+	m.poolLock.RLock()
+	stats := make([]PoolStats, 0, len(m.pools))
+	for _, pool := range m.pools {
+		stats = append(stats, pool.stats())
+	}
+	m.poolLock.RUnlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
 }
@@ -1,11 +1,18 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/MisakiOfScut/go-dage/internal/script"
 	"github.com/MisakiOfScut/go-dage/internal/utils/executor"
 	"github.com/MisakiOfScut/go-dage/internal/utils/log"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -106,7 +113,8 @@ func BenchmarkGraphExecutor_Get(b *testing.B) {
 	gExecutor := gMgr.getGraphExecutor(graphClusterName)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, _ = gExecutor.graphClusterContextPool.Get().(*graphClusterContext)
+		gc, _ := gExecutor.pools[graphName].get()
+		gExecutor.pools[graphName].put(gc)
 	}
 }
 
@@ -358,3 +366,1949 @@ func BenchmarkGraphManager_Execute_DataDrivenGraph_Single_OneGR(b *testing.B) {
 		_ = <-d
 	}
 }
+
+func TestGraphManager_Execute_DataTypeMismatch(t *testing.T) {
+	TestNewDefaultOperatorManager(t)
+	gMgr = NewGraphManager(executor.NewDefaultExecutor(32, uint(runtime.NumCPU())), tOprMgr)
+	testDataType := `
+[[graph]]
+name = "test_graph_dataType"
+[[graph.vertex]]
+op = "DataOperator1"
+[[graph.vertex]]
+op = "TypedDataOperator3"
+`
+	if err := gMgr.Build(graphClusterName, &testDataType); err != nil {
+		t.Fatal(err)
+	}
+	v := executeGraphCtx(t, gMgr, nil, "test_graph_dataType", 0).getVertexCtx("TypedDataOperator3")
+	if v.result != script.VFail || v.operator.(*TypedDataOperator3).executed {
+		t.Fatalf("vertex with mismatched input shouldn't be executed, result:%d", v.result)
+	}
+}
+
+func TestGraphManager_Execute_NumericTypeMismatch(t *testing.T) {
+	TestNewDefaultOperatorManager(t)
+	m := NewGraphManager(executor.NewDefaultExecutor(32, uint(runtime.NumCPU())), tOprMgr)
+	defer m.Stop()
+	// an int declared by the producer isn't an input of float64
+	testBuild := `
+[[graph]]
+name = "test_graph_numeric"
+[[graph.vertex]]
+op = "TypedDataOperator5"
+[[graph.vertex]]
+op = "TypedDataOperator4"
+`
+	if err := m.Build(graphClusterName, &testBuild); err == nil {
+		t.Fatal("an int output shouldn't be passed to a float64 input")
+	}
+
+	// the int emitted by the untyped producer is rejected before injecting it into the operator
+	testExecute := `
+[[graph]]
+name = "test_graph_numeric"
+[[graph.vertex]]
+op = "DataOperator1"
+[[graph.vertex]]
+op = "TypedDataOperator4"
+`
+	if err := m.Build(graphClusterName, &testExecute); err != nil {
+		t.Fatal(err)
+	}
+	v := executeGraphCtx(t, m, nil, "test_graph_numeric", 0).getVertexCtx("TypedDataOperator4")
+	if v.result != script.VFail || v.operator.(*TypedDataOperator4).executed {
+		t.Fatalf("vertex with mismatched input shouldn't be executed, result:%d", v.result)
+	}
+}
+
+func TestGraphManager_OperatorLifecycle(t *testing.T) {
+	counter := &lifecycleCounter{}
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("lifecycleOpr", func() Operator {
+		return newLifecycleOpr(counter, nil)
+	})
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	script := `
+[[graph]]
+name = "test_graph_lifecycle"
+[[graph.vertex]]
+op = "lifecycleOpr"
+start = true
+`
+	if err := m.Build(graphClusterName, &script); err != nil {
+		t.Fatal(err)
+	}
+	if counter.init.Load() != 1 {
+		t.Fatalf("operator should be initialized when building, init:%d", counter.init.Load())
+	}
+	if err := m.Warmup(graphClusterName, 4); err != nil {
+		t.Fatal(err)
+	}
+	if counter.init.Load() != 4 || counter.warmup.Load() != 4 {
+		t.Fatalf("unexpected init:%d, warmup:%d", counter.init.Load(), counter.warmup.Load())
+	}
+
+	// replace the graph cluster
+	if err := m.Build(graphClusterName, &script); err != nil {
+		t.Fatal(err)
+	}
+	if counter.close.Load() != 4 {
+		t.Fatalf("operators of the replaced cluster should be closed, close:%d", counter.close.Load())
+	}
+	var d = make(chan struct{})
+	if err := m.Execute(nil, graphClusterName, "test_graph_lifecycle", 0, func() {
+		d <- struct{}{}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_ = <-d
+	m.Stop()
+	if counter.close.Load() != counter.init.Load() {
+		t.Fatalf("all operators should be closed after stopping, init:%d, close:%d", counter.init.Load(),
+			counter.close.Load())
+	}
+	if counter.probes.Load() == 0 || counter.probes.Load() != counter.created.Load()-counter.init.Load() {
+		t.Fatalf("operators created to check scripts should be closed, created:%d, init:%d, probes:%d",
+			counter.created.Load(), counter.init.Load(), counter.probes.Load())
+	}
+}
+
+func TestGraphManager_OperatorResetSetUpFailed(t *testing.T) {
+	executed := atomic.NewInt32(0)
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("resetFailedOpr", func() Operator {
+		return &resetFailedOpr{nonOp: nonOp{name: "resetFailedOpr"}, executed: executed}
+	})
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	testReset := `
+[[graph]]
+name = "test_graph_reset"
+[[graph.vertex]]
+op = "resetFailedOpr"
+start = true
+`
+	if err := m.Build(graphClusterName, &testReset); err != nil {
+		t.Fatal(err)
+	}
+	g := executeGraphCtx(t, m, nil, "test_graph_reset", 0)
+	if v := g.getVertexCtx("resetFailedOpr"); v.result != script.VOk || executed.Load() != 1 {
+		t.Fatalf("unexpected result:%d, executed:%d", v.result, executed.Load())
+	}
+	// the object returned by Reset fails to be initialized, so it's never executed
+	d := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		g.reset()
+		g.execute(nil, &DAGContext{dagParams: newDagParams()}, 0, func(*ExecutionResult) {}, func() {
+			d <- struct{}{}
+		})
+		<-d
+		if v := g.getVertexCtx("resetFailedOpr"); v.result != script.VFail || executed.Load() != 1 {
+			t.Fatalf("vertex should fail, result:%d, executed:%d", v.result, executed.Load())
+		}
+	}
+}
+
+func TestGraphManager_OperatorInitFailed(t *testing.T) {
+	counter := &lifecycleCounter{}
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("lifecycleOpr", func() Operator {
+		return newLifecycleOpr(counter, fmt.Errorf("connection refused"))
+	})
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	script := `
+[[graph]]
+name = "test_graph_lifecycle"
+[[graph.vertex]]
+op = "lifecycleOpr"
+start = true
+`
+	if err := m.Build(graphClusterName, &script); err == nil {
+		t.Fatal("init error should be returned by build")
+	} else {
+		t.Log(err)
+	}
+}
+
+func TestGraphManager_Interceptor(t *testing.T) {
+	TestNewDefaultOperatorManager(t)
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), tOprMgr)
+	defer m.Stop()
+	var trace []string
+	traceLock := sync.Mutex{}
+	record := func(name string) Interceptor {
+		return func(next OperatorFunc) OperatorFunc {
+			return func(call *OperatorCall) (map[string]interface{}, error) {
+				traceLock.Lock()
+				trace = append(trace, fmt.Sprintf("%s:%s/%s/%s:%d", name, call.Cluster, call.Graph, call.VertexID,
+					len(call.Inputs)))
+				traceLock.Unlock()
+				return next(call)
+			}
+		}
+	}
+	m.UseForOperator("DataOperator2", record("operator"))
+	m.UseForCluster(graphClusterName, record("cluster"))
+	m.UseForCluster("other_cluster", record("other"))
+	m.Use(record("engine"))
+
+	testDataDriven := `
+[[graph]]
+name = "test_graph_interceptor"
+[[graph.vertex]]
+op = "DataOperator1"
+[[graph.vertex]]
+op = "DataOperator2"
+`
+	if err := m.Build(graphClusterName, &testDataDriven); err != nil {
+		t.Fatal(err)
+	}
+	var d = make(chan struct{})
+	if err := m.Execute(nil, graphClusterName, "test_graph_interceptor", 0, func() {
+		d <- struct{}{}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_ = <-d
+	expected := "[engine:test_graphCluster_0/test_graph_interceptor/DataOperator1:0 " +
+		"cluster:test_graphCluster_0/test_graph_interceptor/DataOperator1:0 " +
+		"engine:test_graphCluster_0/test_graph_interceptor/DataOperator2:2 " +
+		"cluster:test_graphCluster_0/test_graph_interceptor/DataOperator2:2 " +
+		"operator:test_graphCluster_0/test_graph_interceptor/DataOperator2:2]"
+	if fmt.Sprint(trace) != expected {
+		t.Fatalf("unexpected trace:%v", trace)
+	}
+
+	// fault injection
+	m.UseForOperator("DataOperator1", func(next OperatorFunc) OperatorFunc {
+		return func(call *OperatorCall) (map[string]interface{}, error) {
+			return nil, fmt.Errorf("injected fault")
+		}
+	})
+	g := executeGraphCtx(t, m, nil, "test_graph_interceptor", 0)
+	if g.getVertexCtx("DataOperator1").result != script.VFail {
+		t.Fatal("vertex should fail with the injected fault")
+	}
+
+	// the interceptors of an operator wrap the vertexes referring to it by its versioned id or aliases
+	oprMgr := NewDefaultOperatorManager()
+	_ = oprMgr.RegisterOperator("score@1.0", func() Operator { return &nonOp{name: "score"} })
+	if err := oprMgr.Alias("rank", "score"); err != nil {
+		t.Fatal(err)
+	}
+	m2 := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m2.Stop()
+	trace = nil
+	m2.UseForOperator("score", record("operator"))
+	testAlias := `
+[[graph]]
+name = "test_graph_alias"
+[[graph.vertex]]
+id = "versioned"
+op = "score@1.0"
+start = true
+next = ["aliased"]
+[[graph.vertex]]
+id = "aliased"
+op = "rank"
+`
+	if err := m2.Build(graphClusterName, &testAlias); err != nil {
+		t.Fatal(err)
+	}
+	executeGraphCtx(t, m2, nil, "test_graph_alias", 0)
+	expected = "[operator:test_graphCluster_0/test_graph_alias/versioned:0 " +
+		"operator:test_graphCluster_0/test_graph_alias/aliased:0]"
+	if fmt.Sprint(trace) != expected {
+		t.Fatalf("unexpected trace:%v", trace)
+	}
+}
+
+// executeGraphCtx executes a graph with a context from the pool, and returns the context without resetting it,
+// so that the results of vertexes can be checked
+func executeGraphCtx(t *testing.T, m *GraphManager, parent context.Context, graphName string,
+	timeoutMillisecond int64) *graphContext {
+	gc, _ := executeGraphCtxWithResult(t, m, parent, graphName, timeoutMillisecond)
+	return gc
+}
+
+func executeGraphCtxWithResult(t *testing.T, m *GraphManager, parent context.Context, graphName string,
+	timeoutMillisecond int64) (*graphContext, *ExecutionResult) {
+	gc, err := m.getGraphExecutor(graphClusterName).pools[graphName].get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result *ExecutionResult
+	var d = make(chan struct{})
+	gc.execute(parent, &DAGContext{dagParams: newDagParams()}, timeoutMillisecond, func(r *ExecutionResult) {
+		result = r
+	}, func() {
+		d <- struct{}{}
+	})
+	_ = <-d
+	return gc, result
+}
+
+func TestGraphManager_GraphSettings(t *testing.T) {
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("flakyOpr", func() Operator {
+		return &flakyOpr{nonOp: nonOp{name: "flakyOpr"}, failures: 2}
+	})
+	oprMgr.RegisterOperator("stableOpr", func() Operator {
+		return &flakyOpr{nonOp: nonOp{name: "stableOpr"}}
+	})
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	testSettings := `
+[[graph]]
+name = "test_graph_retry"
+timeout_ms = 1000
+default_retry = 2
+
+[[graph.vertex]]
+op = "flakyOpr"
+start = true
+next = ["sleep"]
+
+[[graph.vertex]]
+id = "sleep"
+op = "dage/sleep"
+args = { duration_ms = 1000 }
+timeout_ms = 10
+retry = 1
+
+[[graph]]
+name = "test_graph_timeout"
+timeout_ms = 30
+
+[[graph.vertex]]
+id = "sleep"
+op = "dage/sleep"
+args = { duration_ms = 1000 }
+start = true
+next = ["stableOpr"]
+
+[[graph.vertex]]
+op = "stableOpr"
+
+[[graph]]
+name = "test_graph_fail_fast"
+fail_fast = true
+
+[[graph.vertex]]
+op = "dage/fail"
+start = true
+
+[[graph.vertex]]
+id = "sleep"
+op = "dage/sleep"
+args = { duration_ms = 30 }
+start = true
+next = ["stableOpr"]
+
+[[graph.vertex]]
+op = "stableOpr"
+`
+	if err := m.Build(graphClusterName, &testSettings); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	g := executeGraphCtx(t, m, nil, "test_graph_retry", 0)
+	if v := g.getVertexCtx("flakyOpr"); v.result != script.VOk || v.operator.(*flakyOpr).calls.Load() != 3 {
+		t.Fatalf("flakyOpr should succeed after 2 retries, result:%d", v.result)
+	}
+	if v := g.getVertexCtx("sleep"); v.result != script.VFail || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("sleep should fail because of the vertex timeout, result:%d, cost:%v", v.result, time.Since(start))
+	}
+
+	start = time.Now()
+	g, result := executeGraphCtxWithResult(t, m, nil, "test_graph_timeout", 0)
+	if v := g.getVertexCtx("stableOpr"); v.result != script.VAll || v.operator.(*flakyOpr).calls.Load() != 0 ||
+		time.Since(start) > 500*time.Millisecond || result.Status != ExecutionTimeout {
+		t.Fatalf("stableOpr should be skipped because of the graph timeout, result:%d, cost:%v, status:%s",
+			v.result, time.Since(start), result.Status)
+	}
+	// the timeout passed by the caller overrides the script
+	start = time.Now()
+	g = executeGraphCtx(t, m, nil, "test_graph_timeout", 2000)
+	if v := g.getVertexCtx("stableOpr"); v.result != script.VOk || time.Since(start) < time.Second {
+		t.Fatalf("stableOpr should be executed, result:%d, cost:%v", v.result, time.Since(start))
+	}
+
+	g = executeGraphCtx(t, m, nil, "test_graph_fail_fast", 0)
+	if v := g.getVertexCtx("stableOpr"); v.result != script.VAll || v.operator.(*flakyOpr).calls.Load() != 0 {
+		t.Fatalf("stableOpr should be skipped because of fail fast, result:%d", v.result)
+	}
+}
+
+func TestGraphManager_ExecuteContext(t *testing.T) {
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("stableOpr", func() Operator {
+		return &flakyOpr{nonOp: nonOp{name: "stableOpr"}}
+	})
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	testNested := `
+[[graph]]
+name = "test_graph_nested"
+timeout_ms = 2000
+
+[[graph.vertex]]
+id = "sleep"
+op = "dage/sleep"
+args = { duration_ms = 1000 }
+start = true
+next = ["stableOpr"]
+
+[[graph.vertex]]
+op = "stableOpr"
+`
+	if err := m.Build(graphClusterName, &testNested); err != nil {
+		t.Fatal(err)
+	}
+
+	// the deadline of parent comes first
+	parent, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	g := executeGraphCtx(t, m, parent, "test_graph_nested", 0)
+	if v := g.getVertexCtx("stableOpr"); v.result != script.VAll || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("stableOpr should be skipped because of the deadline of parent, result:%d, cost:%v", v.result,
+			time.Since(start))
+	}
+
+	// the timeout of the execution comes first
+	parent, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start = time.Now()
+	g = executeGraphCtx(t, m, parent, "test_graph_nested", 30)
+	if time.Since(start) > 500*time.Millisecond || g.getEndTime() > time.Now().UnixMilli() {
+		t.Fatalf("execution should be timeout after 30ms, cost:%v", time.Since(start))
+	}
+
+	// a canceled parent skips the remaining vertexes
+	parent, cancel = context.WithCancel(context.Background())
+	cancel()
+	var d = make(chan struct{})
+	if err := m.ExecuteContext(parent, nil, graphClusterName, "test_graph_nested", 0, func() {
+		d <- struct{}{}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-d:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("execution with a canceled parent should end immediately")
+	}
+}
+
+func TestGraphManager_TimeoutInLastVertex(t *testing.T) {
+	release := make(chan struct{})
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("blockOpr", func() Operator {
+		return &blockOpr{nonOp: nonOp{name: "blockOpr"}, release: release}
+	})
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	testTimeout := `
+[[graph]]
+name = "test_graph_timeout"
+timeout_ms = 20
+[[graph.vertex]]
+op = "blockOpr"
+start = true
+`
+	if err := m.Build(graphClusterName, &testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	// the last vertex ignores the deadline, and succeeds after it
+	time.AfterFunc(100*time.Millisecond, func() {
+		close(release)
+	})
+	g, result := executeGraphCtxWithResult(t, m, nil, "test_graph_timeout", 0)
+	if v := g.getVertexCtx("blockOpr"); v.result != script.VOk || result.Status != ExecutionTimeout ||
+		!errors.Is(result.Err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected vertex result:%d, result:%+v", v.result, result)
+	}
+}
+
+func TestGraphManager_PoolPerGraph(t *testing.T) {
+	counter := &lifecycleCounter{}
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("lifecycleOpr", func() Operator {
+		return newLifecycleOpr(counter, nil)
+	})
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	testPools := `
+[[graph]]
+name = "test_graph_a"
+[[graph.vertex]]
+op = "lifecycleOpr"
+start = true
+
+[[graph]]
+name = "test_graph_b"
+[[graph.vertex]]
+op = "lifecycleOpr"
+start = true
+`
+	if err := m.Build(graphClusterName, &testPools); err != nil {
+		t.Fatal(err)
+	}
+	if counter.init.Load() != 2 {
+		t.Fatalf("every graph should build a context in advance, init:%d", counter.init.Load())
+	}
+	pool := m.getGraphExecutor(graphClusterName).pools["test_graph_a"]
+	var contexts []*graphContext
+	for i := 0; i < 3; i++ {
+		gc, err := pool.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contexts = append(contexts, gc)
+	}
+	if counter.init.Load() != 4 {
+		t.Fatalf("only the contexts of test_graph_a should be built, init:%d", counter.init.Load())
+	}
+	for _, gc := range contexts {
+		pool.put(gc)
+	}
+}
+
+func TestGraphManager_Abort(t *testing.T) {
+	release := make(chan struct{})
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("stableOpr", func() Operator {
+		return &flakyOpr{nonOp: nonOp{name: "stableOpr"}}
+	})
+	oprMgr.RegisterOperator("blockOpr", func() Operator {
+		return &blockOpr{nonOp: nonOp{name: "blockOpr"}, release: release}
+	})
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	var abortCalls atomic.Int32
+	m.UseForOperator("abortOpr", func(next OperatorFunc) OperatorFunc {
+		return func(call *OperatorCall) (map[string]interface{}, error) {
+			abortCalls.Inc()
+			return nil, fmt.Errorf("bad request: %w", ErrAbortGraph)
+		}
+	})
+	oprMgr.RegisterOperator("abortOpr", func() Operator {
+		return &nonOp{name: "abortOpr"}
+	})
+	testAbort := `
+[[graph]]
+name = "test_graph_critical"
+
+[[graph.vertex]]
+op = "dage/fail"
+args = { message = "critical failure" }
+critical = true
+start = true
+
+[[graph.vertex]]
+op = "blockOpr"
+start = true
+next = ["stableOpr"]
+
+[[graph.vertex]]
+op = "stableOpr"
+
+[[graph]]
+name = "test_graph_abort_error"
+
+[[graph.vertex]]
+op = "abortOpr"
+retry = 3
+start = true
+next = ["stableOpr"]
+
+[[graph.vertex]]
+op = "stableOpr"
+
+[[graph]]
+name = "test_graph_failed"
+
+[[graph.vertex]]
+op = "dage/fail"
+args = { message = "non-critical failure" }
+start = true
+next = ["stableOpr"]
+
+[[graph.vertex]]
+op = "stableOpr"
+`
+	if err := m.Build(graphClusterName, &testAbort); err != nil {
+		t.Fatal(err)
+	}
+
+	// the done callback is called without waiting for the blocked operator
+	results := make(chan *ExecutionResult, 1)
+	if err := m.ExecuteWithResult(nil, nil, graphClusterName, "test_graph_critical", 0,
+		func(result *ExecutionResult) {
+			results <- result
+		}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case result := <-results:
+		if result.Status != ExecutionAborted || result.Vertex != BuiltinFail ||
+			result.Err.Error() != "critical failure" {
+			t.Fatalf("unexpected result:%+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the done callback should be called once the critical vertex failed")
+	}
+	close(release)
+
+	// blockOpr returns after the execution is aborted, so stableOpr is never dispatched
+	g, err := m.getGraphExecutor(graphClusterName).pools["test_graph_critical"].get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked := make(chan struct{})
+	g.getVertexCtx("blockOpr").operator.(*blockOpr).release = blocked
+	recycled := make(chan struct{})
+	g.execute(nil, &DAGContext{dagParams: newDagParams()}, 0, func(result *ExecutionResult) {
+		results <- result
+	}, func() {
+		close(recycled)
+	})
+	result := <-results
+	close(blocked)
+	<-recycled
+	if v := g.getVertexCtx("stableOpr"); v.result != script.VAll || v.operator.(*flakyOpr).calls.Load() != 0 ||
+		result.Status != ExecutionAborted {
+		t.Fatalf("stableOpr should be skipped after aborting, result:%d, status:%s", v.result, result.Status)
+	}
+
+	g, result = executeGraphCtxWithResult(t, m, nil, "test_graph_abort_error", 0)
+	if abortCalls.Load() != 1 || result.Status != ExecutionAborted || !errors.Is(result.Err, ErrAbortGraph) ||
+		g.getVertexCtx("stableOpr").operator.(*flakyOpr).calls.Load() != 0 {
+		t.Fatalf("ErrAbortGraph should abort the execution without retrying, calls:%d, result:%+v",
+			abortCalls.Load(), result)
+	}
+
+	g, result = executeGraphCtxWithResult(t, m, nil, "test_graph_failed", 0)
+	if result.Status != ExecutionFailed || result.Err.Error() != "non-critical failure" ||
+		g.getVertexCtx("stableOpr").operator.(*flakyOpr).calls.Load() != 1 {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+}
+
+func TestGraphManager_Finally(t *testing.T) {
+	ch := make(chan string, 1)
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("stableOpr", func() Operator {
+		return &flakyOpr{nonOp: nonOp{name: "stableOpr"}}
+	})
+	oprMgr.RegisterOperator("auditOpr", func() Operator {
+		return &auditOpr{nonOp: nonOp{name: "auditOpr"}, ch: ch}
+	})
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	testFinally := `
+[[graph]]
+name = "test_graph_timeout"
+timeout_ms = 30
+
+[[graph.vertex]]
+op = "stableOpr"
+start = true
+next = ["sleep"]
+
+[[graph.vertex]]
+id = "sleep"
+op = "dage/sleep"
+args = { duration_ms = 1000 }
+next = ["noop"]
+
+[[graph.vertex]]
+id = "noop"
+op = "dage/noop"
+
+[[graph.vertex]]
+op = "auditOpr"
+finally = true
+
+[[graph]]
+name = "test_graph_abort"
+
+[[graph.vertex]]
+op = "dage/fail"
+critical = true
+start = true
+next = ["stableOpr"]
+
+[[graph.vertex]]
+op = "stableOpr"
+
+[[graph.vertex]]
+op = "auditOpr"
+finally = true
+
+[[graph]]
+name = "test_graph_only_finally"
+
+[[graph.vertex]]
+op = "auditOpr"
+finally = true
+`
+	if err := m.Build(graphClusterName, &testFinally); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"test_graph_timeout":      "map[noop:skipped sleep:failed stableOpr:ok] <nil>",
+		"test_graph_abort":        "map[dage/fail:failed stableOpr:skipped] <nil>",
+		"test_graph_only_finally": "map[] <nil>",
+	}
+	for graph, expected := range cases {
+		results := make(chan *ExecutionResult, 1)
+		if err := m.ExecuteWithResult(nil, nil, graphClusterName, graph, 0, func(result *ExecutionResult) {
+			results <- result
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if audit := <-ch; audit != expected {
+			t.Fatalf("graph:%s, unexpected audit:%s", graph, audit)
+		}
+		t.Logf("graph:%s, status:%s", graph, (<-results).Status)
+	}
+}
+
+func TestGraphManager_Compensate(t *testing.T) {
+	var trace []string
+	traceLock := &sync.Mutex{}
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("DataOperator1", func() Operator { return &DataOperator1{} })
+	oprMgr.RegisterOperator("DataOperator2", func() Operator { return &DataOperator2{} })
+	for _, name := range []string{"unreserve", "refund", "notify"} {
+		name := name
+		oprMgr.RegisterOperator(name, func() Operator {
+			return &recordOpr{name: name, lock: traceLock, trace: &trace}
+		})
+	}
+	oprMgr.RegisterOperator("restore", func() Operator {
+		return &recordOpr{name: "restore", declared: []string{"input:d2", "output:d2", "d3"}, lock: traceLock,
+			trace: &trace}
+	})
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	testCompensate := `
+[[graph]]
+name = "test_graph_order"
+
+[[graph.vertex]]
+id = "reserve"
+op = "DataOperator1"
+compensate_op = "unreserve"
+
+[[graph.vertex]]
+id = "charge"
+op = "DataOperator2"
+compensate_op = "refund"
+next = ["ship"]
+
+[[graph.vertex]]
+id = "ship"
+op = "dage/fail"
+compensate_op = "unreserve"
+
+[[graph.vertex]]
+op = "notify"
+finally = true
+`
+	if err := m.Build(graphClusterName, &testCompensate); err != nil {
+		t.Fatal(err)
+	}
+	_, result := executeGraphCtxWithResult(t, m, nil, "test_graph_order", 0)
+	// ship failed, so it isn't compensated
+	if fmt.Sprint(trace) != "[refund[d1 d2] unreserve[d1 d2] notify[]]" || result.Status != ExecutionFailed ||
+		fmt.Sprint(result.Compensations) != "[{charge refund <nil>} {reserve unreserve <nil>}]" {
+		t.Fatalf("unexpected trace:%v, result:%+v", trace, result)
+	}
+
+	// only the declared inputs are injected, and the data rewritten in place is injected with prefixes
+	testDeclared := `
+[[graph]]
+name = "test_graph_declared"
+
+[[graph.vertex]]
+id = "reserve"
+op = "DataOperator1"
+start = true
+next = ["rewrite"]
+
+[[graph.vertex]]
+id = "rewrite"
+op = "dage/rename"
+args = { mapping = { d1 = "d3", d2 = "d2" } }
+input = [{name = "d1", id = "d1"}, {name = "d2", id = "d2"}]
+output = [{name = "d2", id = "d2_rewritten"}, {name = "d3", id = "d3"}]
+compensate_op = "restore"
+next = ["ship"]
+
+[[graph.vertex]]
+id = "ship"
+op = "dage/fail"
+`
+	if err := m.Build(graphClusterName, &testDeclared); err != nil {
+		t.Fatal(err)
+	}
+	trace = nil
+	_, result = executeGraphCtxWithResult(t, m, nil, "test_graph_declared", 0)
+	if fmt.Sprint(trace) != "[restore[d3 input:d2 output:d2]]" ||
+		fmt.Sprint(result.Compensations) != "[{rewrite restore <nil>}]" {
+		t.Fatalf("unexpected trace:%v, result:%+v", trace, result)
+	}
+	// all data is injected into the compensating operator declaring no inputs
+	testUndeclared := strings.Replace(testDeclared, `"restore"`, `"unreserve"`, 1)
+	if err := m.Build(graphClusterName, &testUndeclared); err != nil {
+		t.Fatal(err)
+	}
+	trace = nil
+	_, result = executeGraphCtxWithResult(t, m, nil, "test_graph_declared", 0)
+	if fmt.Sprint(trace) != "[unreserve[d1 d3 input:d2 output:d2]]" ||
+		fmt.Sprint(result.Compensations) != "[{rewrite unreserve <nil>}]" {
+		t.Fatalf("unexpected trace:%v, result:%+v", trace, result)
+	}
+	// the compensating operator can't declare d2 without the prefixes
+	testAmbiguous := strings.Replace(testDeclared, `"restore"`, `"DataOperator2"`, 1)
+	if err := m.Build(graphClusterName, &testAmbiguous); err == nil ||
+		!strings.Contains(err.Error(), "data:d2 is both an input and an output") {
+		t.Fatalf("unexpected err:%v", err)
+	}
+
+	// no compensations if the execution is ok
+	testCompensate = strings.Replace(testCompensate, `op = "dage/fail"`, `op = "dage/noop"`, 1)
+	if err := m.Build(graphClusterName, &testCompensate); err != nil {
+		t.Fatal(err)
+	}
+	trace = nil
+	_, result = executeGraphCtxWithResult(t, m, nil, "test_graph_order", 0)
+	if fmt.Sprint(trace) != "[notify[]]" || result.Status != ExecutionOk || len(result.Compensations) != 0 {
+		t.Fatalf("unexpected trace:%v, result:%+v", trace, result)
+	}
+}
+
+func TestGraphManager_Outcome(t *testing.T) {
+	var trace []string
+	traceLock := &sync.Mutex{}
+	oprMgr := NewDefaultOperatorManager()
+	for _, outcome := range []string{"hit", "miss"} {
+		outcome := outcome
+		oprMgr.RegisterOperator("cache_"+outcome, func() Operator { return &outcomeOpr{outcome: outcome} })
+	}
+	for _, name := range []string{"load", "render"} {
+		name := name
+		oprMgr.RegisterOperator(name, func() Operator {
+			return &recordOpr{name: name, lock: traceLock, trace: &trace}
+		})
+	}
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	testOutcome := `
+[[graph]]
+name = "test_graph_outcome"
+
+[[graph.vertex]]
+id = "cache"
+op = "cache_miss"
+start = true
+next_on = { hit = ["render"], miss = ["load"], stale = ["load"] }
+
+[[graph.vertex]]
+op = "load"
+
+[[graph.vertex]]
+op = "render"
+`
+	if err := m.Build(graphClusterName, &testOutcome); err != nil {
+		t.Fatal(err)
+	}
+	_, result := executeGraphCtxWithResult(t, m, nil, "test_graph_outcome", 0)
+	if fmt.Sprint(trace) != "[load[]]" || result.Status != ExecutionOk {
+		t.Fatalf("unexpected trace:%v, result:%+v", trace, result)
+	}
+
+	testOutcome = strings.Replace(testOutcome, "cache_miss", "cache_hit", 1)
+	if err := m.Build(graphClusterName, &testOutcome); err != nil {
+		t.Fatal(err)
+	}
+	trace = nil
+	_, result = executeGraphCtxWithResult(t, m, nil, "test_graph_outcome", 0)
+	if fmt.Sprint(trace) != "[render[]]" || result.Status != ExecutionOk {
+		t.Fatalf("unexpected trace:%v, result:%+v", trace, result)
+	}
+
+	// outcomes not declared by the operator
+	testOutcome = strings.Replace(testOutcome, "stale =", "expired =", 1)
+	if err := m.Build(graphClusterName, &testOutcome); err == nil {
+		t.Fatal("undeclared outcome should fail")
+	}
+}
+
+func TestGraphManager_Split(t *testing.T) {
+	var trace []string
+	traceLock := &sync.Mutex{}
+	oprMgr := NewDefaultOperatorManager()
+	for _, name := range []string{"rank_v1", "rank_v2"} {
+		name := name
+		oprMgr.RegisterOperator(name, func() Operator {
+			return &recordOpr{name: name, lock: traceLock, trace: &trace}
+		})
+	}
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	testSplit := `
+[[graph]]
+name = "test_graph_split"
+
+[[graph.vertex]]
+id = "init"
+op = "dage/set_param"
+args = { params = { user_id = "42" } }
+start = true
+next = ["exp"]
+
+[[graph.vertex]]
+id = "exp"
+split = { key = "user_id", seed = 1, buckets = { control = 1, treatment = 1 }, param = "bucket" }
+next_on = { control = ["rank_v1"], treatment = ["rank_v2"] }
+
+[[graph.vertex]]
+op = "rank_v1"
+next = ["check"]
+
+[[graph.vertex]]
+op = "rank_v2"
+next = ["check"]
+
+[[graph.vertex]]
+id = "check"
+op = "dage/assert"
+args = { expr = "bucket == 'control' || bucket == 'treatment'" }
+`
+	if err := m.Build(graphClusterName, &testSplit); err != nil {
+		t.Fatal(err)
+	}
+	_, result := executeGraphCtxWithResult(t, m, nil, "test_graph_split", 0)
+	bucket := result.Buckets["exp"]
+	expected := map[string]string{"control": "[rank_v1[]]", "treatment": "[rank_v2[]]"}[bucket]
+	if fmt.Sprint(trace) != expected || result.Status != ExecutionOk {
+		t.Fatalf("unexpected trace:%v, result:%+v", trace, result)
+	}
+	// the assignment is deterministic
+	for i := 0; i < 10; i++ {
+		trace = nil
+		if _, result = executeGraphCtxWithResult(t, m, nil, "test_graph_split", 0); result.Buckets["exp"] != bucket ||
+			fmt.Sprint(trace) != expected {
+			t.Fatalf("unexpected trace:%v, result:%+v", trace, result)
+		}
+	}
+
+	// the split vertex fails without the key param
+	testSplit = strings.Replace(testSplit, "{ user_id =", "{ uid =", 1)
+	if err := m.Build(graphClusterName, &testSplit); err != nil {
+		t.Fatal(err)
+	}
+	trace = nil
+	_, result = executeGraphCtxWithResult(t, m, nil, "test_graph_split", 0)
+	if len(trace) != 0 || len(result.Buckets) != 0 {
+		t.Fatalf("unexpected trace:%v, result:%+v", trace, result)
+	}
+}
+
+func TestGraphManager_ExecutorSaturated(t *testing.T) {
+	testSaturated := `
+[[graph]]
+name = "test_graph_saturated"
+
+[[graph.vertex]]
+id = "sleep1"
+op = "dage/sleep"
+args = { duration_ms = 1000 }
+start = true
+
+[[graph.vertex]]
+id = "sleep2"
+op = "dage/sleep"
+args = { duration_ms = 1000 }
+start = true
+
+[[graph.vertex]]
+id = "sleep3"
+op = "dage/sleep"
+args = { duration_ms = 1000 }
+start = true
+`
+	m := NewGraphManager(executor.NewDefaultExecutor(1, 1, executor.WithSaturationPolicy(executor.PolicyReject)),
+		NewDefaultOperatorManager())
+	defer m.Stop()
+	if err := m.Build(graphClusterName, &testSaturated); err != nil {
+		t.Fatal(err)
+	}
+	// the rejection of start vertexes is returned instead of being passed to the done callback
+	start := time.Now()
+	err := m.ExecuteWithResult(nil, nil, graphClusterName, "test_graph_saturated", 0,
+		func(result *ExecutionResult) {
+			t.Errorf("the done callback shouldn't be called, result:%+v", result)
+		})
+	if !errors.Is(err, executor.ErrRejected) || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("unexpected err:%v, cost:%v", err, time.Since(start))
+	}
+
+	m2 := NewGraphManager(executor.NewDefaultExecutor(1, 1, executor.WithSaturationPolicy(executor.PolicyCallerRuns)),
+		NewDefaultOperatorManager())
+	defer m2.Stop()
+	testSaturated = strings.ReplaceAll(testSaturated, "duration_ms = 1000", "duration_ms = 10")
+	if err := m2.Build(graphClusterName, &testSaturated); err != nil {
+		t.Fatal(err)
+	}
+	if _, result := executeGraphCtxWithResult(t, m2, nil, "test_graph_saturated", 0); result.Status != ExecutionOk {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+}
+
+func TestGraphManager_ReplaceTaskExecutor(t *testing.T) {
+	testChain := `
+[[graph]]
+name = "test_graph_chain"
+
+[[graph.vertex]]
+id = "sleep1"
+op = "dage/sleep"
+args = { duration_ms = 20 }
+start = true
+next = ["sleep2"]
+
+[[graph.vertex]]
+id = "sleep2"
+op = "dage/sleep"
+args = { duration_ms = 20 }
+next = ["sleep3"]
+
+[[graph.vertex]]
+id = "sleep3"
+op = "dage/sleep"
+args = { duration_ms = 20 }
+`
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), NewDefaultOperatorManager())
+	if err := m.Build(graphClusterName, &testChain); err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan *ExecutionResult, 10)
+	for i := 0; i < 10; i++ {
+		if err := m.ExecuteWithResult(nil, nil, graphClusterName, "test_graph_chain", 0,
+			func(result *ExecutionResult) {
+				results <- result
+			}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// replace the executor while the executions are running
+	time.Sleep(10 * time.Millisecond)
+	m.ReplaceTaskExecutor(executor.NewDefaultExecutor(32, 8))
+	for i := 0; i < 10; i++ {
+		if result := <-results; result.Status != ExecutionOk {
+			t.Fatalf("unexpected result:%+v", result)
+		}
+	}
+
+	m.Stop()
+	if err := m.ExecuteWithResult(nil, nil, graphClusterName, "test_graph_chain", 0,
+		func(result *ExecutionResult) {
+			t.Errorf("the done callback shouldn't be called, result:%+v", result)
+		}); !errors.Is(err, executor.ErrExecutorStopped) {
+		t.Fatalf("unexpected err:%v", err)
+	}
+}
+
+func TestGraphManager_Shutdown(t *testing.T) {
+	testShutdown := `
+[[graph]]
+name = "test_graph_fast"
+
+[[graph.vertex]]
+op = "dage/sleep"
+args = { duration_ms = 20 }
+start = true
+
+[[graph]]
+name = "test_graph_slow"
+
+[[graph.vertex]]
+op = "dage/sleep"
+args = { duration_ms = 5000 }
+start = true
+`
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), NewDefaultOperatorManager())
+	if err := m.Build(graphClusterName, &testShutdown); err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan *ExecutionResult, 2)
+	for _, graph := range []string{"test_graph_fast", "test_graph_slow"} {
+		if err := m.ExecuteWithResult(nil, nil, graphClusterName, graph, 0, func(result *ExecutionResult) {
+			results <- result
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	cutShort, err := m.Shutdown(ctx)
+	if err != context.DeadlineExceeded || len(cutShort) != 1 || cutShort[0].Graph != "test_graph_slow" ||
+		time.Since(start) > time.Second {
+		t.Fatalf("unexpected err:%v, cut short:%+v, cost:%v", err, cutShort, time.Since(start))
+	}
+	if result := <-results; result.Status != ExecutionOk {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+	if result := <-results; result.Status != ExecutionAborted || result.Err != ErrShutdown {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+	if err = m.ExecuteWithResult(nil, nil, graphClusterName, "test_graph_fast", 0, nil); err != ErrShutdown {
+		t.Fatalf("unexpected err:%v", err)
+	}
+
+	// all executions are completed before the deadline
+	m = NewGraphManager(executor.NewDefaultExecutor(32, 8), NewDefaultOperatorManager())
+	if err = m.Build(graphClusterName, &testShutdown); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.ExecuteWithResult(nil, nil, graphClusterName, "test_graph_fast", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cutShort, err = m.Shutdown(context.Background()); err != nil || len(cutShort) != 0 {
+		t.Fatalf("unexpected err:%v, cut short:%+v", err, cutShort)
+	}
+
+	// executions starting concurrently with shutting down are either rejected or aborted
+	m = NewGraphManager(executor.NewDefaultExecutor(32, 8), NewDefaultOperatorManager())
+	if err = m.Build(graphClusterName, &testShutdown); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m.ExecuteWithResult(nil, nil, graphClusterName, "test_graph_slow", 0, nil) == nil {
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = m.Shutdown(canceled); err != context.Canceled {
+		t.Fatalf("unexpected err:%v", err)
+	}
+	wg.Wait()
+}
+
+func TestExecutionRegistry_AbortAll(t *testing.T) {
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), NewDefaultOperatorManager())
+	defer m.Stop()
+	testAbort := `
+[[graph]]
+name = "test_graph_abort"
+[[graph.vertex]]
+op = "dage/noop"
+start = true
+`
+	if err := m.Build(graphClusterName, &testAbort); err != nil {
+		t.Fatal(err)
+	}
+	gc, err := m.getGraphExecutor(graphClusterName).pools["test_graph_abort"].get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newExecutionRegistry()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	recycled := make(chan struct{})
+	gc.prepare(nil, &DAGContext{dagParams: newDagParams()}, 0, func(result *ExecutionResult) {
+		// the done closure calls the registry, and the execution ends while it's being aborted
+		_ = r.wait(canceled)
+		r.remove(gc, func() {
+			close(recycled)
+		})
+		select {
+		case <-recycled:
+			t.Error("the context shouldn't be recycled before aborting returns")
+		default:
+		}
+	}, func() {})
+	if err = r.add(gc, graphClusterName, "test_graph_abort"); err != nil {
+		t.Fatal(err)
+	}
+	aborted := make(chan []ExecutionInfo)
+	go func() {
+		aborted <- r.abortAll(ErrShutdown)
+	}()
+	select {
+	case infos := <-aborted:
+		if len(infos) != 1 || infos[0].Graph != "test_graph_abort" {
+			t.Fatalf("unexpected aborted executions:%+v", infos)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("done closures calling the registry shouldn't deadlock aborting")
+	}
+	<-recycled
+	if err = r.wait(canceled); err != nil {
+		t.Fatalf("the recycled execution should be removed, err:%v", err)
+	}
+}
+
+func TestGraphManager_ExecutorPool(t *testing.T) {
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), NewDefaultOperatorManager())
+	defer m.Stop()
+	if err := m.RegisterExecutor(InlinePool, executor.NewDefaultExecutor(1, 1)); err == nil {
+		t.Fatal("inline pool is reserved")
+	}
+	if err := m.RegisterExecutor("io", executor.NewDefaultExecutor(32, 8)); err != nil {
+		t.Fatal(err)
+	}
+	testPool := `
+[[graph]]
+name = "test_graph_pool"
+pool = "io"
+
+[[graph.vertex]]
+id = "fetch1"
+op = "dage/noop"
+start = true
+next = ["check"]
+
+[[graph.vertex]]
+id = "fetch2"
+op = "dage/noop"
+start = true
+next = ["check"]
+
+[[graph.vertex]]
+id = "check"
+cond = "1 > 0"
+pool = "inline"
+next = ["score"]
+
+[[graph.vertex]]
+id = "score"
+op = "dage/noop"
+pool = "default"
+`
+	if err := m.Build(graphClusterName, &testPool); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, result := executeGraphCtxWithResult(t, m, nil, "test_graph_pool", 0); result.Status != ExecutionOk {
+			t.Fatalf("unexpected result:%+v", result)
+		}
+	}
+	// the last vertex of an execution is counted as completed after the execution is recycled
+	var stats []PoolStats
+	for i := 0; i < 100; i++ {
+		if stats = m.PoolStats(); fmt.Sprint(stats) == "[{default 10 0 0 10} {inline 0 0 0 10} {io 20 0 0 20}]" {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if fmt.Sprint(stats) != "[{default 10 0 0 10} {inline 0 0 0 10} {io 20 0 0 20}]" {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+
+	testPool = strings.Replace(testPool, `pool = "default"`, `pool = "cpu"`, 1)
+	if err := m.Build(graphClusterName, &testPool); err == nil {
+		t.Fatal("unknown pool should fail")
+	} else {
+		t.Log(err)
+	}
+}
+
+// chainScript returns a graph executing n nonOps one by one, the i-th vertex is in pools[i%len(pools)]
+func chainScript(n int, pools ...string) string {
+	sb := strings.Builder{}
+	sb.WriteString("[[graph]]\nname = \"test_graph_chain\"\n")
+	for i := 1; i <= n; i++ {
+		sb.WriteString(fmt.Sprintf("\n[[graph.vertex]]\nop = \"nonOp%d\"\npool = \"%s\"\n", i, pools[i%len(pools)]))
+		if i == 1 {
+			sb.WriteString("start = true\n")
+		}
+		if i < n {
+			sb.WriteString(fmt.Sprintf("next = [\"nonOp%d\"]\n", i+1))
+		}
+	}
+	return sb.String()
+}
+
+func TestGraphManager_ChainFusion(t *testing.T) {
+	TestNewDefaultOperatorManager(t)
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), tOprMgr)
+	defer m.Stop()
+	if err := m.RegisterExecutor("other", executor.NewDefaultExecutor(32, 8)); err != nil {
+		t.Fatal(err)
+	}
+	testChain := `
+[[graph]]
+name = "test_graph_chain"
+
+[[graph.vertex]]
+op = "nonOp1"
+start = true
+next = ["check"]
+
+[[graph.vertex]]
+id = "check"
+cond = "1 > 0"
+next_on_ok = ["nonOp2"]
+
+[[graph.vertex]]
+op = "nonOp2"
+next = ["nonOp3", "nonOp4"]
+
+[[graph.vertex]]
+op = "nonOp3"
+pool = "other"
+
+[[graph.vertex]]
+op = "nonOp4"
+pool = "other"
+inline = true
+next = ["nonOp5"]
+
+[[graph.vertex]]
+op = "nonOp5"
+`
+	if err := m.Build(graphClusterName, &testChain); err != nil {
+		t.Fatal(err)
+	}
+	if _, result := executeGraphCtxWithResult(t, m, nil, "test_graph_chain", 0); result.Status != ExecutionOk {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+	// nonOp1 is submitted, and then check, nonOp2, nonOp4 and nonOp5 are executed in its goroutine. nonOp4 is
+	// counted in its own pool, and nonOp3 is submitted to its pool.
+	expected := "[{default 1 0 0 4} {inline 0 0 0 0} {other 1 0 0 2}]"
+	var stats []PoolStats
+	for i := 0; i < 100; i++ {
+		if stats = m.PoolStats(); fmt.Sprint(stats) == expected {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if fmt.Sprint(stats) != expected {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+}
+
+func benchmarkChain(b *testing.B, fused bool) {
+	t := &testing.T{}
+	TestNewDefaultOperatorManager(t)
+	m := NewGraphManager(executor.NewDefaultExecutor(32, uint(runtime.NumCPU())), tOprMgr)
+	defer m.Stop()
+	m.getExecutorPool(DefaultPool).unfused = !fused
+	script := chainScript(10, DefaultPool)
+	if err := m.Build(graphClusterName, &script); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var d = make(chan struct{})
+		for pb.Next() {
+			if err := m.Execute(nil, graphClusterName, "test_graph_chain", 0, func() {
+				d <- struct{}{}
+			}); err != nil {
+				b.Error(err)
+				return
+			}
+			_ = <-d
+		}
+	})
+}
+
+// BenchmarkGraphManager_Execute_Chain10_Fused executes a chain of 10 nonOps in the default pool, which is fused
+func BenchmarkGraphManager_Execute_Chain10_Fused(b *testing.B) {
+	benchmarkChain(b, true)
+}
+
+// BenchmarkGraphManager_Execute_Chain10_Unfused executes the same chain in the same pool with fusion turned off,
+// every vertex is submitted to the executor like before fusing
+func BenchmarkGraphManager_Execute_Chain10_Unfused(b *testing.B) {
+	benchmarkChain(b, false)
+}
+
+func TestGraphManager_Priority(t *testing.T) {
+	var trace []string
+	traceLock := &sync.Mutex{}
+	release := make(chan struct{})
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("gate", func() Operator { return &blockOpr{release: release} })
+	for _, name := range []string{"interactive", "batch", "chain1", "chain2", "leaf1", "leaf2"} {
+		name := name
+		oprMgr.RegisterOperator(name, func() Operator {
+			return &recordOpr{name: name, lock: traceLock, trace: &trace}
+		})
+	}
+	m := NewGraphManager(executor.NewPriorityExecutor(32, 1), oprMgr)
+	defer m.Stop()
+	testPriority := `
+[[graph]]
+name = "test_graph_gate"
+[[graph.vertex]]
+op = "gate"
+start = true
+
+[[graph]]
+name = "test_graph_interactive"
+[[graph.vertex]]
+op = "interactive"
+start = true
+
+[[graph]]
+name = "test_graph_batch"
+[[graph.vertex]]
+op = "batch"
+start = true
+
+[[graph]]
+name = "test_graph_wide"
+
+[[graph.vertex]]
+op = "leaf1"
+start = true
+
+[[graph.vertex]]
+op = "leaf2"
+start = true
+
+[[graph.vertex]]
+op = "chain1"
+weight = 1
+start = true
+next = ["chain2"]
+
+[[graph.vertex]]
+op = "chain2"
+weight = 10
+`
+	if err := m.Build(graphClusterName, &testPriority); err != nil {
+		t.Fatal(err)
+	}
+	gc, err := m.getGraphExecutor(graphClusterName).pools["test_graph_wide"].get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := gc.profile
+	m.getGraphExecutor(graphClusterName).pools["test_graph_wide"].put(gc)
+	if profile.get("chain1").criticalPath.Load() != 11000 || profile.get("chain2").criticalPath.Load() != 10000 ||
+		profile.get("leaf1").criticalPath.Load() != 1 {
+		t.Fatal("unexpected critical path")
+	}
+
+	wg := sync.WaitGroup{}
+	execute := func(parent context.Context, graphName string) {
+		gc, err := m.getGraphExecutor(graphClusterName).pools[graphName].get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		gc.execute(parent, &DAGContext{dagParams: newDagParams()}, 0, func(*ExecutionResult) {}, wg.Done)
+	}
+	// the gate occupies the only worker, so the following vertexes are queued and executed by priority
+	execute(context.Background(), "test_graph_gate")
+	for i := 0; i < 100 && m.PoolStats()[0].Running == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	execute(WithPriorityClass(context.Background(), PriorityBatch), "test_graph_batch")
+	execute(context.Background(), "test_graph_wide")
+	execute(WithPriorityClass(context.Background(), PriorityInteractive), "test_graph_interactive")
+	close(release)
+	wg.Wait()
+	if len(trace) != 6 || fmt.Sprint(trace[:3]) != "[interactive[] chain1[] chain2[]]" || trace[5] != "batch[]" {
+		t.Fatalf("unexpected trace:%v", trace)
+	}
+
+	leaf := &vertexProfile{}
+	leaf.observe(8 * time.Millisecond)
+	leaf.observe(0)
+	if leaf.cost() != 7001 {
+		t.Fatalf("unexpected cost:%d", leaf.cost())
+	}
+}
+
+// fanOutScript is a small graph, nonOp1 is followed by nonOp2 to nonOp5 in parallel, which are joined by nonOp6
+const fanOutScript = `
+[[graph]]
+name = "test_graph_fan_out"
+
+[[graph.vertex]]
+op = "nonOp1"
+start = true
+next = ["nonOp2", "nonOp3", "nonOp4", "nonOp5"]
+
+[[graph.vertex]]
+op = "nonOp2"
+next = ["nonOp6"]
+
+[[graph.vertex]]
+op = "nonOp3"
+next = ["nonOp6"]
+
+[[graph.vertex]]
+op = "nonOp4"
+next = ["nonOp6"]
+
+[[graph.vertex]]
+op = "nonOp5"
+next = ["nonOp6"]
+
+[[graph.vertex]]
+op = "nonOp6"
+`
+
+func TestGraphManager_WorkStealing(t *testing.T) {
+	TestNewDefaultOperatorManager(t)
+	m := NewGraphManager(executor.NewWorkStealingExecutor(32, 4), tOprMgr)
+	defer m.Stop()
+	script := fanOutScript
+	if err := m.Build(graphClusterName, &script); err != nil {
+		t.Fatal(err)
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, result := executeGraphCtxWithResult(t, m, nil, "test_graph_fan_out", 0); result.Status !=
+					ExecutionOk {
+					t.Errorf("unexpected result:%+v", result)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// nonOp1 and 3 of the parallel vertexes are submitted, the other one and nonOp6 are executed inline
+	expected := "[{default 3200 0 0 4800} {inline 0 0 0 0}]"
+	var stats []PoolStats
+	for i := 0; i < 100; i++ {
+		if stats = m.PoolStats(); fmt.Sprint(stats) == expected {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if fmt.Sprint(stats) != expected {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+}
+
+func benchmarkSmallGraphs(b *testing.B, e executor.Executor) {
+	t := &testing.T{}
+	TestNewDefaultOperatorManager(t)
+	m := NewGraphManager(e, tOprMgr)
+	defer m.Stop()
+	script := fanOutScript
+	if err := m.Build(graphClusterName, &script); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var d = make(chan struct{})
+		for pb.Next() {
+			if err := m.Execute(nil, graphClusterName, "test_graph_fan_out", 0, func() {
+				d <- struct{}{}
+			}); err != nil {
+				b.Error(err)
+				return
+			}
+			_ = <-d
+		}
+	})
+}
+
+// BenchmarkGraphManager_Execute_FanOut_Default executes many concurrent small graphs with the default executor
+func BenchmarkGraphManager_Execute_FanOut_Default(b *testing.B) {
+	benchmarkSmallGraphs(b, executor.NewDefaultExecutor(32, uint(runtime.NumCPU())))
+}
+
+// BenchmarkGraphManager_Execute_FanOut_WorkStealing executes many concurrent small graphs with the work-stealing
+// executor
+func BenchmarkGraphManager_Execute_FanOut_WorkStealing(b *testing.B) {
+	benchmarkSmallGraphs(b, executor.NewWorkStealingExecutor(32, uint(runtime.NumCPU())))
+}
+
+func TestGraphManager_ResizeExecutor(t *testing.T) {
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 2), NewDefaultOperatorManager())
+	defer m.Stop()
+	if err := m.RegisterExecutor("priority", executor.NewPriorityExecutor(32, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ResizeExecutor("", 4); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := m.ExecutorStats(DefaultPool); err != nil || stats.Workers != 4 {
+		t.Fatalf("unexpected stats:%+v, err:%v", stats, err)
+	}
+	for _, pool := range []string{InlinePool, "priority", "io"} {
+		if err := m.ResizeExecutor(pool, 4); err == nil {
+			t.Fatalf("pool:%s shouldn't be resizable", pool)
+		} else {
+			t.Log(err)
+		}
+	}
+}
+
+func TestGraphManager_OperatorLimit(t *testing.T) {
+	release := make(chan struct{})
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("guarded", func() Operator { return &blockOpr{release: release} },
+		WithLimit(OperatorLimit{MaxConcurrency: 1}))
+	oprMgr.RegisterOperator("rated", func() Operator { return &nonOp{name: "rated"} },
+		WithLimit(OperatorLimit{Rate: 100}))
+	if err := oprMgr.Alias("guarded_alias", "guarded"); err != nil {
+		t.Fatal(err)
+	}
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	testLimit := `
+[[graph]]
+name = "test_graph_guarded"
+
+[[graph.vertex]]
+id = "a"
+op = "guarded"
+start = true
+
+[[graph.vertex]]
+id = "b"
+op = "guarded_alias"
+start = true
+
+[[graph]]
+name = "test_graph_rated"
+
+[[graph.vertex]]
+id = "a"
+op = "rated"
+start = true
+next = ["b"]
+
+[[graph.vertex]]
+id = "b"
+op = "rated"
+next = ["c"]
+
+[[graph.vertex]]
+id = "c"
+op = "rated"
+`
+	if err := m.Build(graphClusterName, &testLimit); err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan *ExecutionResult, 1)
+	if err := m.ExecuteWithResult(context.Background(), nil, graphClusterName, "test_graph_guarded", 0,
+		func(r *ExecutionResult) { results <- r }); err != nil {
+		t.Fatal(err)
+	}
+	// the vertexes of the operator and its alias share the limiter, one of them waits for the other
+	expected := "[{guarded {1 0 0} 1 1 1 0} {rated {0 100 0} 0 0 0 0}]"
+	var stats []LimiterStats
+	for i := 0; i < 100; i++ {
+		if stats = m.LimiterStats(); fmt.Sprint(stats) == expected {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if fmt.Sprint(stats) != expected {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+	close(release)
+	if result := <-results; result.Status != ExecutionOk {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+
+	start := time.Now()
+	if _, result := executeGraphCtxWithResult(t, m, nil, "test_graph_rated", 0); result.Status != ExecutionOk ||
+		time.Since(start) < 15*time.Millisecond {
+		t.Fatalf("unexpected result:%+v, cost:%v", result, time.Since(start))
+	}
+
+	// the limit in the script takes precedence over the registered one
+	release = make(chan struct{})
+	defer close(release)
+	testLimit = `
+[[limit]]
+op = "guarded_alias"
+max_concurrency = 1
+policy = "fail_fast"
+` + testLimit
+	if err := m.Build(graphClusterName, &testLimit); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	if err := m.ExecuteWithResult(context.Background(), nil, graphClusterName, "test_graph_guarded", 0,
+		func(r *ExecutionResult) { results <- r }); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && m.LimiterStats()[0].Rejected == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := m.LimiterStats(); stats[0].Policy != LimitFailFast || stats[0].Running != 1 || stats[0].Rejected != 1 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+	release <- struct{}{}
+	if result := <-results; result.Status != ExecutionFailed || !errors.Is(result.Err, ErrLimited) {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+
+	// the limit set by another graph cluster takes effect on the built contexts, unless the cluster fails to build
+	testFree := `
+[[graph]]
+name = "test_graph_free"
+
+[[graph.vertex]]
+id = "a"
+op = "free"
+start = true
+
+[[graph.vertex]]
+id = "b"
+op = "free"
+start = true
+`
+	oprMgr.RegisterOperator("free", func() Operator { return &blockOpr{release: release} })
+	if err := m.Build("test_cluster_free", &testFree); err != nil {
+		t.Fatal(err)
+	}
+	testOther := `
+[[limit]]
+op = "free"
+max_concurrency = 1
+policy = "fail_fast"
+
+[[graph]]
+name = "test_graph_other"
+
+[[graph.vertex]]
+op = "dage/sleep"
+args = { duration_ms = "bad" }
+start = true
+`
+	if err := m.Build("test_cluster_other", &testOther); err == nil {
+		t.Fatal("the graph cluster shouldn't be built with bad args")
+	}
+	if stats := m.LimiterStats(); len(stats) != 2 {
+		t.Fatalf("the limit shouldn't be set by the failed build, stats:%+v", stats)
+	}
+	testOther = strings.Replace(testOther, `"bad"`, "1", 1)
+	if err := m.Build("test_cluster_other", &testOther); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ExecuteWithResult(context.Background(), nil, "test_cluster_free", "test_graph_free", 0,
+		func(r *ExecutionResult) { results <- r }); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && m.LimiterStats()[0].Rejected == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	release <- struct{}{}
+	if result := <-results; result.Status != ExecutionFailed || !errors.Is(result.Err, ErrLimited) {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+
+	// the limits conflicting with the ones set by another graph cluster are rejected, the equal ones are shared
+	testConflict := strings.Replace(testOther, "max_concurrency = 1", "max_concurrency = 2", 1)
+	if err := m.Build("test_cluster_conflict", &testConflict); err == nil ||
+		!strings.Contains(err.Error(), "conflicts") {
+		t.Fatalf("unexpected error:%v", err)
+	}
+	if err := m.Build("test_cluster_conflict", &testOther); err != nil {
+		t.Fatal(err)
+	}
+
+	// the registered limits take effect again once the graph clusters limiting the operators are replaced
+	testLimit = testLimit[strings.Index(testLimit, "[[graph]]"):]
+	if err := m.Build(graphClusterName, &testLimit); err != nil {
+		t.Fatal(err)
+	}
+	testOther = testOther[strings.Index(testOther, "[[graph]]"):]
+	for _, cluster := range []string{"test_cluster_other", "test_cluster_conflict"} {
+		if stats := m.LimiterStats(); len(stats) != 3 || stats[0].Operator != "free" || stats[1].Policy != LimitQueue {
+			t.Fatalf("unexpected stats:%+v", stats)
+		}
+		if err := m.Build(cluster, &testOther); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := m.LimiterStats(); fmt.Sprint(stats) != "[{guarded {1 0 0} 0 0 3 1} {rated {0 100 0} 0 0 3 0}]" {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+}
+
+func TestGraphManager_CircuitBreaker(t *testing.T) {
+	var trace []string
+	traceLock := &sync.Mutex{}
+	down := atomic.NewBool(true)
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("backend", func() Operator { return &backendOpr{down: down} },
+		WithBreaker(BreakerOptions{MinRequests: 3, OpenDuration: 50 * time.Millisecond}))
+	oprMgr.RegisterOperator("fallback", func() Operator {
+		return &recordOpr{name: "fallback", lock: traceLock, trace: &trace}
+	})
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	var events []string
+	m.OnBreakerStateChange(func(event BreakerEvent) {
+		traceLock.Lock()
+		defer traceLock.Unlock()
+		events = append(events, fmt.Sprintf("%s:%s->%s", event.Operator, event.From, event.To))
+	})
+	testBreaker := `
+[[graph]]
+name = "test_graph_breaker"
+
+[[graph.vertex]]
+id = "fetch"
+op = "backend"
+start = true
+next_on_fail = ["fallback"]
+
+[[graph.vertex]]
+op = "fallback"
+`
+	if err := m.Build(graphClusterName, &testBreaker); err != nil {
+		t.Fatal(err)
+	}
+	// the breaker opens after 3 failures
+	for i := 0; i < 3; i++ {
+		if _, result := executeGraphCtxWithResult(t, m, nil, "test_graph_breaker", 0); result.Status !=
+			ExecutionFailed || errors.Is(result.Err, ErrCircuitOpen) {
+			t.Fatalf("unexpected result:%+v", result)
+		}
+	}
+	down.Store(false)
+	if _, result := executeGraphCtxWithResult(t, m, nil, "test_graph_breaker", 0); result.Status !=
+		ExecutionFailed || !errors.Is(result.Err, ErrCircuitOpen) || result.Vertex != "fetch" {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+	if stats := m.BreakerStats(); fmt.Sprint(stats) != "[{backend open 3 3 1}]" {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+	// a probe closes the breaker after the open duration
+	time.Sleep(60 * time.Millisecond)
+	if _, result := executeGraphCtxWithResult(t, m, nil, "test_graph_breaker", 0); result.Status != ExecutionOk {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+	if stats := m.BreakerStats(); fmt.Sprint(stats) != "[{backend closed 0 0 1}]" {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+	if len(trace) != 4 || fmt.Sprint(events) != "[backend:closed->open backend:open->half-open "+
+		"backend:half-open->closed]" {
+		t.Fatalf("unexpected trace:%v, events:%v", trace, events)
+	}
+
+	// probes are limited in the half-open state, and a failed probe opens the breaker again
+	b := newCircuitBreaker("probe", nil)
+	b.configure(BreakerOptions{MinRequests: 2, OpenDuration: time.Millisecond, HalfOpenProbes: 2})
+	stale, err := b.allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	generation, err := b.allow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.done(generation, fmt.Errorf("backend is down"))
+	b.done(generation, fmt.Errorf("backend is down"))
+	time.Sleep(2 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if generation, err = b.allow(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = b.allow(); err != ErrCircuitOpen {
+		t.Fatalf("unexpected err:%v", err)
+	}
+	b.cancel(generation)
+	if _, err = b.allow(); err != nil {
+		t.Fatal(err)
+	}
+	// the call allowed before the breaker opened isn't a probe
+	b.done(stale, nil)
+	b.done(generation, nil)
+	if stats := b.stats(); stats.State != BreakerHalfOpen {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+	b.done(generation, context.DeadlineExceeded)
+	if stats := b.stats(); stats.State != BreakerOpen || stats.Rejected != 1 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+}
+
+func TestGraphManager_CircuitOpenOutcome(t *testing.T) {
+	var trace []string
+	traceLock := &sync.Mutex{}
+	oprMgr := NewDefaultOperatorManager()
+	oprMgr.RegisterOperator("backend", func() Operator { return &backendOpr{down: atomic.NewBool(true)} },
+		WithBreaker(BreakerOptions{MinRequests: 1, OpenDuration: time.Minute}))
+	for _, name := range []string{"fallback", "alert", "render"} {
+		name := name
+		oprMgr.RegisterOperator(name, func() Operator {
+			return &recordOpr{name: name, lock: traceLock, trace: &trace}
+		})
+	}
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	testCircuitOpen := `
+[[graph]]
+name = "test_graph_circuit_open"
+
+[[graph.vertex]]
+id = "fetch"
+op = "backend"
+start = true
+next_on = { circuit_open = ["fallback", "render"] }
+next_on_fail = ["alert"]
+
+[[graph.vertex]]
+op = "fallback"
+
+[[graph.vertex]]
+op = "alert"
+
+[[graph.vertex]]
+op = "render"
+`
+	if err := m.Build(graphClusterName, &testCircuitOpen); err != nil {
+		t.Fatal(err)
+	}
+	// the failure opens the breaker, only the vertexes on failure are executed
+	if _, result := executeGraphCtxWithResult(t, m, nil, "test_graph_circuit_open", 0); result.Status !=
+		ExecutionFailed || errors.Is(result.Err, ErrCircuitOpen) {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+	if fmt.Sprint(trace) != "[alert[]]" {
+		t.Fatalf("unexpected trace:%v", trace)
+	}
+	// the open breaker routes the execution to the fallbacks
+	trace = nil
+	gc, result := executeGraphCtxWithResult(t, m, nil, "test_graph_circuit_open", 0)
+	if result.Status != ExecutionFailed || !errors.Is(result.Err, ErrCircuitOpen) {
+		t.Fatalf("unexpected result:%+v", result)
+	}
+	sort.Strings(trace)
+	if outcome := gc.getVertexCtx("fetch").outcome; fmt.Sprint(trace) != "[alert[] fallback[] render[]]" ||
+		outcome != CircuitOpenOutcome {
+		t.Fatalf("unexpected trace:%v, outcome:%s", trace, outcome)
+	}
+
+	testCircuitOpen = strings.Replace(testCircuitOpen, "circuit_open =", "circuit_closed =", 1)
+	if err := m.Build(graphClusterName, &testCircuitOpen); err == nil {
+		t.Fatal("undeclared outcome should fail")
+	}
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"context"
+	"github.com/MisakiOfScut/go-dage/internal/script"
+	"go.uber.org/atomic"
+	"time"
+)
+
+const (
+	PriorityBatch       = -1 // executions scheduled after the others sharing the same pool
+	PriorityNormal      = 0
+	PriorityInteractive = 1 // executions scheduled before the others sharing the same pool
+)
+
+// recompute the critical paths from the observed latencies every profileInterval executions
+const profileInterval = 64
+
+type priorityClassKey struct{}
+
+// WithPriorityClass returns a child of ctx, which makes the executions executed with it have the priority class.
+// Vertexes of executions with a higher class are executed first by executors implementing PriorityExecutor.
+func WithPriorityClass(ctx context.Context, class int) context.Context {
+	return context.WithValue(ctx, priorityClassKey{}, class)
+}
+
+func getPriorityClass(ctx context.Context) int {
+	class, _ := ctx.Value(priorityClassKey{}).(int)
+	return class
+}
+
+// graphProfile estimates the remaining critical path of every vertex of a graph, which is the max cost of the paths
+// from the vertex to the end. The cost of a vertex is its static weight, or the EWMA of its observed latency if it
+// has no weight. It is shared by all contexts of the graph.
+type graphProfile struct {
+	vertexes   []*vertexProfile // in reverse topological order
+	byID       map[string]*vertexProfile
+	executions atomic.Uint64
+}
+
+type vertexProfile struct {
+	weight       int64        // static weight in microseconds, zero if unset
+	latency      atomic.Int64 // EWMA of the observed latency in microseconds
+	criticalPath atomic.Int64 // in microseconds
+	next         []*vertexProfile
+}
+
+func newGraphProfile(graph *script.Graph) *graphProfile {
+	p := &graphProfile{byID: make(map[string]*vertexProfile, len(graph.Vertex))}
+	for i, _ := range graph.Vertex {
+		p.byID[graph.Vertex[i].ID] = &vertexProfile{weight: graph.Vertex[i].Weight * 1000}
+	}
+	// post-order DFS, so that every vertex is after its next vertexes
+	visited := make(map[string]bool, len(graph.Vertex))
+	var visit func(v *script.Vertex)
+	visit = func(v *script.Vertex) {
+		if visited[v.ID] {
+			return
+		}
+		visited[v.ID] = true
+		vp := p.byID[v.ID]
+		for id, next := range v.NextVertex {
+			visit(next)
+			vp.next = append(vp.next, p.byID[id])
+		}
+		p.vertexes = append(p.vertexes, vp)
+	}
+	for i, _ := range graph.Vertex {
+		visit(&graph.Vertex[i])
+	}
+	p.recompute()
+	return p
+}
+
+func (p *graphProfile) get(id string) *vertexProfile {
+	return p.byID[id]
+}
+
+// onExecutionDone recomputes the critical paths periodically
+func (p *graphProfile) onExecutionDone() {
+	if p.executions.Inc()%profileInterval == 0 {
+		p.recompute()
+	}
+}
+
+func (p *graphProfile) recompute() {
+	for _, v := range p.vertexes {
+		var maxNext int64
+		for _, next := range v.next {
+			if path := next.criticalPath.Load(); path > maxNext {
+				maxNext = path
+			}
+		}
+		v.criticalPath.Store(v.cost() + maxNext)
+	}
+}
+
+func (v *vertexProfile) cost() int64 {
+	if v.weight > 0 {
+		return v.weight
+	}
+	if latency := v.latency.Load(); latency > 0 {
+		return latency
+	}
+	return 1
+}
+
+// observe a latency of the vertex, the EWMA weights the new sample by 1/8
+func (v *vertexProfile) observe(d time.Duration) {
+	sample := d.Microseconds() + 1
+	if latency := v.latency.Load(); latency > 0 {
+		sample = latency + (sample-latency)/8
+	}
+	v.latency.Store(sample)
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"go.uber.org/atomic"
+	"sync"
+)
+
+// OperatorCall is an execution of an operator in a vertex, which is passed through interceptors.
+type OperatorCall struct {
+	Cluster  string                 // graph cluster name
+	Graph    string                 // graph name
+	VertexID string                 // vertex id
+	OprID    string                 // operator id in the script
+	Operator Operator               // operator object
+	Inputs   map[string]interface{} // the data injected into the operator, map input name to value
+	Ctx      *DAGContext
+}
+
+type OperatorFunc func(call *OperatorCall) (map[string]interface{}, error)
+
+// Interceptor wraps an OperatorFunc with cross-cutting behaviors, e.x. timing, logging and fault injection.
+// 	e.x.
+// 		func(next OperatorFunc) OperatorFunc {
+// 			return func(call *OperatorCall) (map[string]interface{}, error) {
+// 				start := time.Now()
+// 				defer func() { metrics.Observe(call.Graph, call.VertexID, time.Since(start)) }()
+// 				return next(call)
+// 			}
+// 		}
+//
+type Interceptor func(next OperatorFunc) OperatorFunc
+
+// executeOperator is the innermost OperatorFunc
+func executeOperator(call *OperatorCall) (map[string]interface{}, error) {
+	return call.Operator.OnExecute(call.Ctx)
+}
+
+// interceptorRegistry stores the interceptors of the engine, graph clusters and operators.
+// Engine's interceptors are the outermost, followed by cluster's and operator's ones. In the same level, the first
+// registered interceptor is the outermost.
+type interceptorRegistry struct {
+	lock       sync.RWMutex
+	version    atomic.Uint64 // increased after registering, used to invalidate built chains
+	global     []Interceptor
+	byCluster  map[string][]Interceptor
+	byOperator []operatorInterceptor // in the order of registering
+	resolve    func(oprID string) string
+}
+
+// operatorInterceptor is an interceptor of the operator specified by an id or alias
+type operatorInterceptor struct {
+	oprID       string
+	interceptor Interceptor
+}
+
+// newInterceptorRegistry creates a registry, resolve returns the registered id of an operator id or alias, so that
+// the interceptors of an operator wrap the vertexes referring to it by any of its ids and aliases.
+func newInterceptorRegistry(resolve func(oprID string) string) *interceptorRegistry {
+	return &interceptorRegistry{
+		byCluster: make(map[string][]Interceptor),
+		resolve:   resolve,
+	}
+}
+
+func (r *interceptorRegistry) use(interceptors ...Interceptor) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.global = append(r.global, interceptors...)
+	r.version.Inc()
+}
+
+func (r *interceptorRegistry) useForCluster(cluster string, interceptors ...Interceptor) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.byCluster[cluster] = append(r.byCluster[cluster], interceptors...)
+	r.version.Inc()
+}
+
+func (r *interceptorRegistry) useForOperator(oprID string, interceptors ...Interceptor) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, interceptor := range interceptors {
+		r.byOperator = append(r.byOperator, operatorInterceptor{oprID: oprID, interceptor: interceptor})
+	}
+	r.version.Inc()
+}
+
+// chain builds the OperatorFunc of an operator in a graph cluster, and returns it with the registry's version
+func (r *interceptorRegistry) chain(cluster string, oprID string) (OperatorFunc, uint64) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	f := OperatorFunc(executeOperator)
+	var byOperator []Interceptor
+	if len(r.byOperator) > 0 {
+		id := r.resolve(oprID)
+		for _, o := range r.byOperator {
+			if o.oprID == oprID || r.resolve(o.oprID) == id {
+				byOperator = append(byOperator, o.interceptor)
+			}
+		}
+	}
+	levels := [][]Interceptor{byOperator, r.byCluster[cluster], r.global}
+	for _, interceptors := range levels {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			f = interceptors[i](f)
+		}
+	}
+	return f, r.version.Load()
+}
@@ -2,11 +2,14 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"fmt"
 	"github.com/MisakiOfScut/go-dage/internal/script"
 	"github.com/MisakiOfScut/go-dage/internal/utils/eval"
+	"reflect"
 	"sync"
+	"time"
 )
 
 func init() {
@@ -79,6 +82,31 @@ func (m *defaultDagParams) Clear() {
 type DAGContext struct {
 	dagParams
 	UserData interface{}
+
+	ctx           context.Context
+	vertexResults map[string]VertexResult // only set for finally vertexes
+}
+
+// Context returns the context of the execution, which is done when the execution is timeout or its parent is done.
+// Operators doing IO should pass it down to stop their work in time.
+func (c *DAGContext) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// VertexResults returns the results of all vertexes except finally vertexes in the execution.
+// It's only available in finally vertexes, and returns nil in other vertexes.
+func (c *DAGContext) VertexResults() map[string]VertexResult {
+	return c.vertexResults
+}
+
+// setDeadline makes the context of the execution done at endTimeStamp (in millisecond)
+func (c *DAGContext) setDeadline(endTimeStamp int64) context.CancelFunc {
+	var cancel context.CancelFunc
+	c.ctx, cancel = context.WithDeadline(c.Context(), time.UnixMilli(endTimeStamp))
+	return cancel
 }
 
 type Operator interface {
@@ -90,43 +118,69 @@ type Operator interface {
 	Reset() Operator                                           // if it is able to reset then return itself, otherwise return a new Operator object
 }
 
-type NewOperatorFunction func() Operator
-
-type OperatorManager interface {
-	RegisterOperator(id string, f NewOperatorFunction)
-	GetOperator(id string) Operator
+// Configurable is an optional interface of Operator, which receives the args of its vertex in script.
+// Configure is called before Init, an error fails the building of the graph cluster.
+//
+//	e.x.
+//		[[graph.vertex]]
+//		op = "dage/sleep"
+//		args = { duration_ms = 10 }
+type Configurable interface {
+	Configure(args map[string]interface{}) error
 }
 
-type defaultOperatorManager struct {
-	operators map[string]NewOperatorFunction
+// Initializer is an optional interface of Operator. Init is called once after an operator object is created by its
+// NewOperatorFunction, an error fails the building of the graph cluster.
+type Initializer interface {
+	Init() error
 }
 
-func NewDefaultOperatorManager() *defaultOperatorManager {
-	oprMgr := &defaultOperatorManager{operators: make(map[string]NewOperatorFunction)}
-	oprMgr.addPredefinedOpr()
-	return oprMgr
+// Warmer is an optional interface of Operator. Warmup is called when warming up a graph cluster, operators can
+// establish their connections or fill their caches in it.
+type Warmer interface {
+	Warmup() error
 }
 
-func (m *defaultOperatorManager) addPredefinedOpr() {
-	m.RegisterOperator(script.DAGE_EXPR_OPERATOR, func() Operator {
-		o := new(DAGEExpressionOperator)
-		return o
-	})
+// Closer is an optional interface of Operator. Close is called when an operator object is discarded, which happens
+// when the engine stops, the graph cluster is replaced or the object is evicted from the context pool.
+type Closer interface {
+	Close() error
 }
 
-// RegisterOperator add an operator object create function to opr manager.
-// Attention: add a func with duplicated name will replace the previous one.
-func (m *defaultOperatorManager) RegisterOperator(oprName string, f NewOperatorFunction) {
-	m.operators[oprName] = f
+// TypedDataOperator is an optional interface of Operator. If an operator declares the types of its inputs and
+// outputs, the engine checks the compatibility of every data edge when building graphs and injecting data.
+type TypedDataOperator interface {
+	GetInputsType() map[string]reflect.Type  // map input data's id to its type
+	GetOutputsType() map[string]reflect.Type // map output data's id to its type
 }
 
-func (m *defaultOperatorManager) GetOperator(oprName string) Operator {
-	if v, ok := m.operators[oprName]; ok {
-		return v()
-	}
-	return nil
+// OutcomeDeclarer is an optional interface of Operator. An operator declaring outcomes reports one of them by
+// returning it in its outputs with the key OutcomeKey, vertexes in the next_on table of the vertex are executed
+// according to the reported outcome.
+type OutcomeDeclarer interface {
+	GetOutcomes() []string
 }
 
+// Outcome is the label of the result of an operator, such as hit, miss or stale.
+type Outcome string
+
+// OutcomeKey is the output key of the outcome reported by an operator, whose value is an Outcome or a string.
+// It isn't a data output, so it's removed from the outputs after the operator is executed.
+const OutcomeKey = "__dage_outcome__"
+
+// CircuitOpenOutcome is the outcome of a vertex failed with ErrCircuitOpen, the vertexes in its next_on are executed
+// even though the vertex fails, and the operator needn't declare it.
+const CircuitOpenOutcome = script.CIRCUIT_OPEN_OUTCOME
+
+// CompensateInputPrefix and CompensateOutputPrefix are prefixed to a name which is both an input and an output of a
+// vertex, when the compensating operator of the vertex declares or gets it, e.x. "input:items" and "output:items".
+const (
+	CompensateInputPrefix  = script.COMPENSATE_INPUT_PREFIX
+	CompensateOutputPrefix = script.COMPENSATE_OUTPUT_PREFIX
+)
+
+type NewOperatorFunction func() Operator
+
 type DAGEExpressionOperator struct {
 }
 
@@ -149,3 +203,27 @@ func (p *DAGEExpressionOperator) GetOutputsID() []string {
 func (p *DAGEExpressionOperator) Reset() Operator {
 	return p
 }
+
+// DAGESplitOperator is the placeholder operator of split vertexes, which are executed by the engine
+type DAGESplitOperator struct {
+}
+
+func (p *DAGESplitOperator) Name() string {
+	return script.DAGE_SPLIT_OPERATOR
+}
+
+func (p *DAGESplitOperator) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	return nil, nil
+}
+func (p *DAGESplitOperator) InjectDepsData(key string, value interface{}) error {
+	return nil
+}
+func (p *DAGESplitOperator) GetInputsID() []string {
+	return nil
+}
+func (p *DAGESplitOperator) GetOutputsID() []string {
+	return nil
+}
+func (p *DAGESplitOperator) Reset() Operator {
+	return p
+}
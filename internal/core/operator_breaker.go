@@ -0,0 +1,279 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/MisakiOfScut/go-dage/internal/utils/log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is the error of vertexes failed immediately because the circuit breaker of their operators is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // operators are called, and their errors are counted
+	BreakerOpen                         // vertexes fail with ErrCircuitOpen without calling their operators
+	BreakerHalfOpen                     // a few probes are called to decide whether to close the breaker
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerOptions configures the circuit breaker of an operator, zero values are replaced by the defaults.
+type BreakerOptions struct {
+	ErrorRate      float64       // the breaker opens when the error rate in a window reaches it, 0.5 by default
+	MinRequests    int           // the min number of calls in a window to open the breaker, 20 by default
+	Window         time.Duration // the window counting calls, 10s by default
+	OpenDuration   time.Duration // how long the breaker stays open before probing, 5s by default
+	HalfOpenProbes int           // the breaker closes after these probes succeeded, 1 by default
+}
+
+func (o BreakerOptions) withDefaults() BreakerOptions {
+	if o.ErrorRate <= 0 {
+		o.ErrorRate = 0.5
+	}
+	if o.MinRequests <= 0 {
+		o.MinRequests = 20
+	}
+	if o.Window <= 0 {
+		o.Window = 10 * time.Second
+	}
+	if o.OpenDuration <= 0 {
+		o.OpenDuration = 5 * time.Second
+	}
+	if o.HalfOpenProbes <= 0 {
+		o.HalfOpenProbes = 1
+	}
+	return o
+}
+
+// BreakerEvent is a state change of the circuit breaker of an operator.
+type BreakerEvent struct {
+	Operator string
+	From     BreakerState
+	To       BreakerState
+	Time     time.Time
+}
+
+// BreakerHook is called synchronously after the state of a circuit breaker changed, it shouldn't block.
+type BreakerHook func(event BreakerEvent)
+
+// BreakerStats is the state of the circuit breaker of an operator.
+type BreakerStats struct {
+	Operator string
+	State    BreakerState
+	Requests int    // calls in the current window
+	Failures int    // failed calls in the current window
+	Rejected uint64 // vertexes failed with ErrCircuitOpen
+}
+
+// circuitBreaker is shared by all vertexes of an operator
+type circuitBreaker struct {
+	name        string
+	lock        sync.Mutex
+	options     BreakerOptions
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int    // probes called in the half-open state
+	succeeded   int    // probes succeeded in the half-open state
+	generation  uint64 // increased by every state change, the results of calls allowed in earlier ones are ignored
+	rejected    uint64
+	notify      func(event BreakerEvent)
+}
+
+func newCircuitBreaker(name string, notify func(event BreakerEvent)) *circuitBreaker {
+	return &circuitBreaker{name: name, windowStart: time.Now(), notify: notify}
+}
+
+func (b *circuitBreaker) configure(options BreakerOptions) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.options = options.withDefaults()
+}
+
+// allow returns ErrCircuitOpen if the breaker is open, or all probes are called in the half-open state. Every
+// allowed call should be ended by done or cancel with the returned generation.
+func (b *circuitBreaker) allow() (uint64, error) {
+	b.lock.Lock()
+	var event *BreakerEvent
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.options.OpenDuration {
+		event = b.transit(BreakerHalfOpen)
+	}
+	var err error
+	switch b.state {
+	case BreakerOpen:
+		err = ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probes < b.options.HalfOpenProbes {
+			b.probes++
+		} else {
+			err = ErrCircuitOpen
+		}
+	}
+	if err != nil {
+		b.rejected++
+	}
+	generation := b.generation
+	b.lock.Unlock()
+	b.emit(event)
+	return generation, err
+}
+
+// done records the result of a call allowed in the generation. Calls canceled by their executions aren't counted,
+// neither are the calls allowed before the last state change, e.x. the calls allowed before the breaker opened can't
+// close the half-open breaker.
+func (b *circuitBreaker) done(generation uint64, err error) {
+	if errors.Is(err, context.Canceled) {
+		b.cancel(generation)
+		return
+	}
+	b.lock.Lock()
+	if generation != b.generation {
+		b.lock.Unlock()
+		return
+	}
+	var event *BreakerEvent
+	switch b.state {
+	case BreakerClosed:
+		if time.Since(b.windowStart) >= b.options.Window {
+			b.windowStart = time.Now()
+			b.requests, b.failures = 0, 0
+		}
+		b.requests++
+		if err != nil {
+			b.failures++
+		}
+		if b.requests >= b.options.MinRequests &&
+			float64(b.failures) >= b.options.ErrorRate*float64(b.requests) {
+			event = b.transit(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if err != nil {
+			event = b.transit(BreakerOpen)
+		} else if b.succeeded++; b.succeeded >= b.options.HalfOpenProbes {
+			event = b.transit(BreakerClosed)
+		}
+	}
+	b.lock.Unlock()
+	b.emit(event)
+}
+
+// cancel a call allowed in the generation which isn't called, so that its probe can be called by another vertex
+func (b *circuitBreaker) cancel(generation uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if generation == b.generation && b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+// transit the breaker to another state, the lock should be held
+func (b *circuitBreaker) transit(to BreakerState) *BreakerEvent {
+	now := time.Now()
+	event := &BreakerEvent{Operator: b.name, From: b.state, To: to, Time: now}
+	b.state = to
+	b.generation++
+	switch to {
+	case BreakerOpen:
+		b.openedAt = now
+	case BreakerHalfOpen:
+		b.probes, b.succeeded = 0, 0
+	case BreakerClosed:
+		b.windowStart = now
+		b.requests, b.failures = 0, 0
+	}
+	return event
+}
+
+func (b *circuitBreaker) emit(event *BreakerEvent) {
+	if event == nil {
+		return
+	}
+	if event.To == BreakerOpen {
+		log.Warnf("operator:%s, circuit breaker changes from %s to %s", event.Operator, event.From, event.To)
+	} else {
+		log.Infof("operator:%s, circuit breaker changes from %s to %s", event.Operator, event.From, event.To)
+	}
+	if b.notify != nil {
+		b.notify(*event)
+	}
+}
+
+func (b *circuitBreaker) stats() BreakerStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return BreakerStats{
+		Operator: b.name,
+		State:    b.state,
+		Requests: b.requests,
+		Failures: b.failures,
+		Rejected: b.rejected,
+	}
+}
+
+// breakerRegistry stores the circuit breakers of the engine and the hooks of their state changes
+type breakerRegistry struct {
+	lock       sync.RWMutex
+	byOperator map[string]*circuitBreaker
+	hooks      []BreakerHook
+}
+
+func newBreakerRegistry() *breakerRegistry {
+	return &breakerRegistry{byOperator: make(map[string]*circuitBreaker)}
+}
+
+func (r *breakerRegistry) getOrCreate(oprID string) *circuitBreaker {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	b, ok := r.byOperator[oprID]
+	if !ok {
+		b = newCircuitBreaker(oprID, r.notify)
+		r.byOperator[oprID] = b
+	}
+	return b
+}
+
+func (r *breakerRegistry) addHook(hook BreakerHook) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+func (r *breakerRegistry) notify(event BreakerEvent) {
+	r.lock.RLock()
+	hooks := r.hooks
+	r.lock.RUnlock()
+	for _, hook := range hooks {
+		hook(event)
+	}
+}
+
+// stats returns the states of all breakers, sorted by operator id
+func (r *breakerRegistry) stats() []BreakerStats {
+	r.lock.RLock()
+	stats := make([]BreakerStats, 0, len(r.byOperator))
+	for _, b := range r.byOperator {
+		stats = append(stats, b.stats())
+	}
+	r.lock.RUnlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Operator < stats[j].Operator
+	})
+	return stats
+}
@@ -0,0 +1,303 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MisakiOfScut/go-dage/internal/script"
+	"go.uber.org/atomic"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrLimited is the error of vertexes failed by the limit of their operators with LimitFailFast.
+var ErrLimited = errors.New("operator is limited")
+
+// LimitPolicy decides how to handle a vertex when the limit of its operator is hit.
+type LimitPolicy int
+
+const (
+	LimitQueue    LimitPolicy = iota // wait for the limit until the vertex is timeout
+	LimitFailFast                    // fail the vertex with ErrLimited
+)
+
+// OperatorLimit throttles an operator in all executions of the engine.
+type OperatorLimit struct {
+	MaxConcurrency int     // the max number of running operators, zero means unlimited
+	Rate           float64 // the max number of operators started per second, zero means unlimited
+	Policy         LimitPolicy
+}
+
+func (l OperatorLimit) isZero() bool {
+	return l.MaxConcurrency <= 0 && l.Rate <= 0
+}
+
+// LimiterStats is the usage of the limit of an operator.
+type LimiterStats struct {
+	Operator string
+	OperatorLimit
+	Running  int    // operators running with a permit
+	Waiting  int    // vertexes waiting for a permit
+	Admitted uint64 // vertexes admitted by the limit
+	Rejected uint64 // vertexes failed by the limit, including the ones timeout when waiting
+}
+
+// operatorLimiter is shared by all vertexes of an operator, its limit can be updated by building scripts
+type operatorLimiter struct {
+	name       string
+	limited    atomic.Bool // the limit isn't zero, vertexes of unlimited operators don't take the lock
+	lock       sync.Mutex
+	limit      OperatorLimit            // the limit in effect
+	registered OperatorLimit            // the limit in the registration of the operator
+	scripts    map[string]OperatorLimit // map graph cluster to the limit set by its script, which are all equal
+	next       time.Time                // the time of the next permit of the rate
+	waiters    []chan struct{}          // vertexes waiting for the concurrency, in FIFO order
+	running    int
+	admitted   uint64
+	rejected   uint64
+}
+
+func newOperatorLimiter(name string) *operatorLimiter {
+	return &operatorLimiter{name: name}
+}
+
+// setRegistered sets the limit in the registration of the operator, which is in effect if no script limits it
+func (l *operatorLimiter) setRegistered(limit OperatorLimit) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.registered = limit
+	l.update()
+}
+
+// scriptLimit returns the limit set by the scripts except the one of cluster
+func (l *operatorLimiter) scriptLimit(except string) (OperatorLimit, string, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for cluster, limit := range l.scripts {
+		if cluster != except {
+			return limit, cluster, true
+		}
+	}
+	return OperatorLimit{}, "", false
+}
+
+// setScript sets the limit set by the script of cluster, or removes it if ok is false
+func (l *operatorLimiter) setScript(cluster string, limit OperatorLimit, ok bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if ok {
+		if l.scripts == nil {
+			l.scripts = make(map[string]OperatorLimit)
+		}
+		l.scripts[cluster] = limit
+	} else {
+		delete(l.scripts, cluster)
+	}
+	l.update()
+}
+
+// update the limit in effect, the lock should be held
+func (l *operatorLimiter) update() {
+	l.limit = l.registered
+	for _, limit := range l.scripts {
+		l.limit = limit
+		break
+	}
+	l.limited.Store(!l.limit.isZero())
+	// the raised concurrency admits the waiting vertexes
+	for len(l.waiters) > 0 && (l.limit.MaxConcurrency <= 0 || l.running < l.limit.MaxConcurrency) {
+		l.running++
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+	}
+}
+
+// acquire a permit of the rate and the concurrency, the permit of the concurrency should be released after the
+// operator returns if held is true, which is false if the operator is unlimited. It returns ErrLimited with
+// LimitFailFast, or ctx.Err() if ctx is done when waiting.
+func (l *operatorLimiter) acquire(ctx context.Context) (held bool, err error) {
+	if !l.limited.Load() {
+		return false, nil
+	}
+	if err = l.waitRate(ctx); err != nil {
+		return false, err
+	}
+	l.lock.Lock()
+	if l.limit.MaxConcurrency <= 0 || l.running < l.limit.MaxConcurrency {
+		l.running++
+		l.admitted++
+		l.lock.Unlock()
+		return true, nil
+	}
+	if l.limit.Policy == LimitFailFast {
+		l.rejected++
+		l.lock.Unlock()
+		return false, ErrLimited
+	}
+	admitted := make(chan struct{})
+	l.waiters = append(l.waiters, admitted)
+	l.lock.Unlock()
+
+	select {
+	case <-admitted:
+		l.lock.Lock()
+		l.admitted++
+		l.lock.Unlock()
+		return true, nil
+	case <-ctx.Done():
+	}
+	l.lock.Lock()
+	for i, w := range l.waiters {
+		if w == admitted {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			l.rejected++
+			l.lock.Unlock()
+			return false, ctx.Err()
+		}
+	}
+	// admitted when ctx is done, give the permit back
+	l.rejected++
+	l.lock.Unlock()
+	l.release()
+	return false, ctx.Err()
+}
+
+// waitRate reserves the next permit of the rate, and waits until the time of it
+func (l *operatorLimiter) waitRate(ctx context.Context) error {
+	l.lock.Lock()
+	if l.limit.Rate <= 0 {
+		l.lock.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	if wait > 0 && l.limit.Policy == LimitFailFast {
+		l.rejected++
+		l.lock.Unlock()
+		return ErrLimited
+	}
+	l.next = l.next.Add(time.Duration(float64(time.Second) / l.limit.Rate))
+	l.lock.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.lock.Lock()
+		l.rejected++
+		l.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// release a permit of the concurrency, which is handed over to the first waiting vertex
+func (l *operatorLimiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.waiters) > 0 && (l.limit.MaxConcurrency <= 0 || l.running <= l.limit.MaxConcurrency) {
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+		return
+	}
+	l.running--
+}
+
+func (l *operatorLimiter) stats() LimiterStats {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return LimiterStats{
+		Operator:      l.name,
+		OperatorLimit: l.limit,
+		Running:       l.running,
+		Waiting:       len(l.waiters),
+		Admitted:      l.admitted,
+		Rejected:      l.rejected,
+	}
+}
+
+// limiterRegistry stores the limiters of the engine, map operator id to its limiter
+type limiterRegistry struct {
+	lock       sync.RWMutex
+	byOperator map[string]*operatorLimiter
+}
+
+func newLimiterRegistry() *limiterRegistry {
+	return &limiterRegistry{byOperator: make(map[string]*operatorLimiter)}
+}
+
+func (r *limiterRegistry) getOrCreate(oprID string) *operatorLimiter {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	l, ok := r.byOperator[oprID]
+	if !ok {
+		l = newOperatorLimiter(oprID)
+		r.byOperator[oprID] = l
+	}
+	return l
+}
+
+// setClusterLimits replaces the limits set by the script of a graph cluster, which maps operator id to its limit.
+// The operators aren't limited by the cluster any more if they aren't in limits. It fails without changing any limit
+// if an operator is limited differently by another graph cluster.
+func (r *limiterRegistry) setClusterLimits(cluster string, limits map[string]OperatorLimit) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for oprID, limit := range limits {
+		l, ok := r.byOperator[oprID]
+		if !ok {
+			continue
+		}
+		if other, otherCluster, ok := l.scriptLimit(cluster); ok && other != limit {
+			return fmt.Errorf("operator:%s is limited by graph cluster:%s with %+v, which conflicts with %+v",
+				oprID, otherCluster, other, limit)
+		}
+	}
+	for oprID, l := range r.byOperator {
+		if _, ok := limits[oprID]; !ok {
+			l.setScript(cluster, OperatorLimit{}, false)
+		}
+	}
+	for oprID, limit := range limits {
+		l, ok := r.byOperator[oprID]
+		if !ok {
+			l = newOperatorLimiter(oprID)
+			r.byOperator[oprID] = l
+		}
+		l.setScript(cluster, limit, true)
+	}
+	return nil
+}
+
+// stats returns the usage of the limiters of limited operators, sorted by operator id
+func (r *limiterRegistry) stats() []LimiterStats {
+	r.lock.RLock()
+	stats := make([]LimiterStats, 0, len(r.byOperator))
+	for _, l := range r.byOperator {
+		if l.limited.Load() {
+			stats = append(stats, l.stats())
+		}
+	}
+	r.lock.RUnlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Operator < stats[j].Operator
+	})
+	return stats
+}
+
+// scriptLimit converts the limit in a script
+func scriptLimit(limit *script.Limit) OperatorLimit {
+	l := OperatorLimit{MaxConcurrency: limit.MaxConcurrency, Rate: limit.PerSecond(), Policy: LimitQueue}
+	if limit.Policy == script.LimitPolicyFailFast {
+		l.Policy = LimitFailFast
+	}
+	return l
+}
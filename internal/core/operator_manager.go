@@ -0,0 +1,386 @@
+package core
+
+import (
+	"fmt"
+	"github.com/MisakiOfScut/go-dage/internal/script"
+	"github.com/MisakiOfScut/go-dage/internal/utils/log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	namespaceSeparator = "/"
+	versionSeparator   = "@"
+)
+
+type OperatorManager interface {
+	RegisterOperator(id string, f NewOperatorFunction, opts ...RegisterOption) error
+	GetOperator(id string) Operator
+}
+
+// OperatorMeta describes a registered operator.
+// An operator id is made up of an optional namespace, a name and an optional version, e.x. "ranking.v2/score@1.0.2".
+type OperatorMeta struct {
+	Name        string // operator name with namespace, e.x. "ranking.v2/score"
+	Namespace   string // e.x. "ranking.v2", empty if the operator has no namespace
+	Version     string // empty if the operator is registered without version
+	Description string
+	Aliases     []string
+	Inputs      []string
+	Outputs     []string
+	Outcomes    []string          // outcome labels declared by the operator, see OutcomeDeclarer
+	Args        map[string]string // map the name of vertex args to their descriptions
+	Limit       OperatorLimit     // overridden by the limit of the operator in scripts
+	Breaker     *BreakerOptions   // nil if the operator has no circuit breaker
+}
+
+// ID returns the full id of the operator, e.x. "ranking.v2/score@1.0.2"
+func (m *OperatorMeta) ID() string {
+	if len(m.Version) == 0 {
+		return m.Name
+	}
+	return m.Name + versionSeparator + m.Version
+}
+
+type RegisterOption func(meta *OperatorMeta)
+
+// WithVersion sets the version of the registered operator, it is the same as registering with "name@version".
+func WithVersion(version string) RegisterOption {
+	return func(meta *OperatorMeta) {
+		meta.Version = version
+	}
+}
+
+func WithDescription(description string) RegisterOption {
+	return func(meta *OperatorMeta) {
+		meta.Description = description
+	}
+}
+
+// WithArgs documents the vertex args accepted by the registered operator.
+func WithArgs(args map[string]string) RegisterOption {
+	return func(meta *OperatorMeta) {
+		meta.Args = args
+	}
+}
+
+// WithLimit throttles the registered operator in all executions of the engine.
+func WithLimit(limit OperatorLimit) RegisterOption {
+	return func(meta *OperatorMeta) {
+		meta.Limit = limit
+	}
+}
+
+// WithBreaker makes the registered operator have a circuit breaker shared by all executions of the engine.
+func WithBreaker(options BreakerOptions) RegisterOption {
+	return func(meta *OperatorMeta) {
+		meta.Breaker = &options
+	}
+}
+
+// WithAliases adds aliases referring to the registered operator (with its version).
+func WithAliases(aliases ...string) RegisterOption {
+	return func(meta *OperatorMeta) {
+		meta.Aliases = append(meta.Aliases, aliases...)
+	}
+}
+
+// parseOperatorID splits "namespace/name@version" into "namespace/name", "namespace" and "version"
+func parseOperatorID(id string) (name string, namespace string, version string) {
+	name = id
+	if idx := strings.LastIndex(name, versionSeparator); idx >= 0 {
+		name, version = name[:idx], name[idx+1:]
+	}
+	if idx := strings.LastIndex(name, namespaceSeparator); idx >= 0 {
+		namespace = name[:idx]
+	}
+	return name, namespace, version
+}
+
+type operatorEntry struct {
+	meta    OperatorMeta
+	newFunc NewOperatorFunction
+}
+
+type defaultOperatorManager struct {
+	lock            sync.RWMutex
+	operators       map[string]map[string]*operatorEntry // map name to version to entry
+	highestVersion  map[string]string                    // map name to the highest registered version
+	aliases         map[string]string                    // map alias to operator id
+	rejectDuplicate bool
+}
+
+func NewDefaultOperatorManager() *defaultOperatorManager {
+	oprMgr := &defaultOperatorManager{
+		operators:      make(map[string]map[string]*operatorEntry),
+		highestVersion: make(map[string]string),
+		aliases:        make(map[string]string),
+	}
+	oprMgr.addPredefinedOpr()
+	return oprMgr
+}
+
+func (m *defaultOperatorManager) addPredefinedOpr() {
+	_ = m.RegisterOperator(script.DAGE_EXPR_OPERATOR, func() Operator {
+		o := new(DAGEExpressionOperator)
+		return o
+	}, WithDescription("evaluates the cond expression of a condition vertex"))
+	_ = m.RegisterOperator(script.DAGE_SPLIT_OPERATOR, func() Operator {
+		return new(DAGESplitOperator)
+	}, WithDescription("assigns the execution to a bucket of a split vertex"))
+	m.addBuiltinOpr()
+}
+
+// SetRejectDuplicate makes RegisterOperator return an error when registering an operator id twice,
+// instead of replacing the previous one.
+func (m *defaultOperatorManager) SetRejectDuplicate(reject bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rejectDuplicate = reject
+}
+
+// RegisterOperator add an operator object create function to opr manager.
+// Attention: add a func with duplicated id will replace the previous one, unless SetRejectDuplicate(true) is called.
+func (m *defaultOperatorManager) RegisterOperator(oprID string, f NewOperatorFunction, opts ...RegisterOption) error {
+	entry, err := newOperatorEntry(oprID, f, opts)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.add(entry)
+}
+
+// OperatorRegistration is an operator registered by RegisterOperators.
+type OperatorRegistration struct {
+	ID      string
+	New     NewOperatorFunction
+	Options []RegisterOption
+}
+
+// RegisterOperators registers operators like RegisterOperator, but nothing is registered if any of them fails.
+func (m *defaultOperatorManager) RegisterOperators(registrations ...OperatorRegistration) error {
+	entries := make([]*operatorEntry, 0, len(registrations))
+	for i, _ := range registrations {
+		entry, err := newOperatorEntry(registrations[i].ID, registrations[i].New, registrations[i].Options)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	// register them to a copy, which replaces the operators after all of them are registered
+	staged := m.clone()
+	for _, entry := range entries {
+		if err := staged.add(entry); err != nil {
+			return err
+		}
+	}
+	m.operators, m.highestVersion, m.aliases = staged.operators, staged.highestVersion, staged.aliases
+	return nil
+}
+
+func newOperatorEntry(oprID string, f NewOperatorFunction, opts []RegisterOption) (*operatorEntry, error) {
+	if f == nil {
+		return nil, fmt.Errorf("operator:%s, new function is nil", oprID)
+	}
+	name, namespace, version := parseOperatorID(oprID)
+	if len(name) == 0 || strings.HasSuffix(name, namespaceSeparator) {
+		return nil, fmt.Errorf("operator id:%s is illegal", oprID)
+	}
+	meta := OperatorMeta{Name: name, Namespace: namespace, Version: version}
+	for _, opt := range opts {
+		opt(&meta)
+	}
+	if len(version) != 0 && meta.Version != version {
+		return nil, fmt.Errorf("operator:%s is registered with a different version:%s", oprID, meta.Version)
+	}
+	return &operatorEntry{meta: meta, newFunc: f}, nil
+}
+
+// add an operator entry, the lock should be held
+func (m *defaultOperatorManager) add(entry *operatorEntry) error {
+	meta := entry.meta
+	if _, ok := m.aliases[meta.Name]; ok {
+		return fmt.Errorf("operator:%s conflicts with an alias", meta.ID())
+	}
+	versions := m.operators[meta.Name]
+	for _, alias := range meta.Aliases {
+		if err := m.checkAlias(alias, meta.ID()); err != nil {
+			return err
+		}
+	}
+	if previous, existed := versions[meta.Version]; existed {
+		if m.rejectDuplicate {
+			return fmt.Errorf("operator:%s is already registered", meta.ID())
+		}
+		log.Warnf("operator:%s is already registered, the previous one will be replaced", meta.ID())
+		for _, alias := range previous.meta.Aliases {
+			delete(m.aliases, alias)
+		}
+	}
+	for _, alias := range meta.Aliases {
+		m.aliases[alias] = meta.ID()
+	}
+	if versions == nil {
+		versions = make(map[string]*operatorEntry)
+		m.operators[meta.Name] = versions
+	}
+	versions[meta.Version] = entry
+	if highest, ok := m.highestVersion[meta.Name]; !ok || compareVersions(meta.Version, highest) > 0 {
+		m.highestVersion[meta.Name] = meta.Version
+	}
+	return nil
+}
+
+// clone copies the operators and aliases, the lock should be held
+func (m *defaultOperatorManager) clone() *defaultOperatorManager {
+	c := &defaultOperatorManager{
+		operators:       make(map[string]map[string]*operatorEntry, len(m.operators)),
+		highestVersion:  make(map[string]string, len(m.highestVersion)),
+		aliases:         make(map[string]string, len(m.aliases)),
+		rejectDuplicate: m.rejectDuplicate,
+	}
+	for name, versions := range m.operators {
+		c.operators[name] = make(map[string]*operatorEntry, len(versions))
+		for version, entry := range versions {
+			c.operators[name][version] = entry
+		}
+	}
+	for name, version := range m.highestVersion {
+		c.highestVersion[name] = version
+	}
+	for alias, target := range m.aliases {
+		c.aliases[alias] = target
+	}
+	return c
+}
+
+// Alias makes alias refer to the operator target, which can be specified with or without version.
+func (m *defaultOperatorManager) Alias(alias string, target string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := m.checkAlias(alias, target); err != nil {
+		return err
+	}
+	if m.getEntry(target) == nil {
+		return fmt.Errorf("alias:%s refers to an unregistered operator:%s", alias, target)
+	}
+	m.aliases[alias] = target
+	return nil
+}
+
+func (m *defaultOperatorManager) checkAlias(alias string, target string) error {
+	if _, ok := m.operators[alias]; ok {
+		return fmt.Errorf("alias:%s conflicts with a registered operator", alias)
+	}
+	if previous, ok := m.aliases[alias]; ok && previous != target {
+		return fmt.Errorf("alias:%s already refers to operator:%s", alias, previous)
+	}
+	return nil
+}
+
+func (m *defaultOperatorManager) GetOperator(oprID string) Operator {
+	m.lock.RLock()
+	entry := m.getEntry(oprID)
+	m.lock.RUnlock()
+	if entry == nil {
+		return nil
+	}
+	return entry.newFunc()
+}
+
+// getMeta returns the metadata of an operator id or alias
+func (m *defaultOperatorManager) getMeta(oprID string) (OperatorMeta, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if entry := m.getEntry(oprID); entry != nil {
+		return entry.meta, true
+	}
+	return OperatorMeta{}, false
+}
+
+// getEntry resolves an operator id or alias. If the id has no version, the highest registered version will be
+// returned, the operator registered without version is lower than any version.
+func (m *defaultOperatorManager) getEntry(oprID string) *operatorEntry {
+	if target, ok := m.aliases[oprID]; ok {
+		oprID = target
+	}
+	name, _, version := parseOperatorID(oprID)
+	versions, ok := m.operators[name]
+	if !ok {
+		return nil
+	}
+	if len(version) == 0 {
+		return versions[m.highestVersion[name]]
+	}
+	return versions[version]
+}
+
+// compareVersions compares versions made up of segments separated by ".", e.x. "1.10.0" is higher than "1.9".
+// The leading digits of segments are compared as numbers, and the rest of them are compared as strings.
+// An empty version is the lowest.
+func compareVersions(a, b string) int {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) - len(b)
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aRest := splitVersionSegment(as[i])
+		bn, bRest := splitVersionSegment(bs[i])
+		if an != bn {
+			if an < bn {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(aRest, bRest); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// splitVersionSegment splits a version segment into its leading number and the rest, e.x. "2-beta" into 2 and "-beta"
+func splitVersionSegment(segment string) (uint64, string) {
+	i := 0
+	for i < len(segment) && segment[i] >= '0' && segment[i] <= '9' {
+		i++
+	}
+	n, _ := strconv.ParseUint(segment[:i], 10, 64)
+	return n, segment[i:]
+}
+
+// ListOperators returns the metadata of all registered operators sorted by id.
+func (m *defaultOperatorManager) ListOperators() []OperatorMeta {
+	m.lock.RLock()
+	var entries []*operatorEntry
+	for _, versions := range m.operators {
+		for _, entry := range versions {
+			entries = append(entries, entry)
+		}
+	}
+	m.lock.RUnlock()
+
+	metas := make([]OperatorMeta, 0, len(entries))
+	for _, entry := range entries {
+		meta := entry.meta
+		// the object is only created to read its declarations, so it isn't set up and is closed right away
+		if opr := entry.newFunc(); opr != nil {
+			meta.Inputs = opr.GetInputsID()
+			meta.Outputs = opr.GetOutputsID()
+			if d, ok := opr.(OutcomeDeclarer); ok {
+				meta.Outcomes = d.GetOutcomes()
+			}
+			closeOperator(opr)
+		}
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].ID() < metas[j].ID()
+	})
+	return metas
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func newNamedNonOp(name string) NewOperatorFunction {
+	return func() Operator {
+		return &nonOp{name: name}
+	}
+}
+
+func TestDefaultOperatorManager_NamespaceAndVersion(t *testing.T) {
+	m := NewDefaultOperatorManager()
+	if err := m.RegisterOperator("ranking.v2/score@1", newNamedNonOp("score1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RegisterOperator("ranking.v2/score", newNamedNonOp("score2"), WithVersion("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RegisterOperator("ranking.v2/score@3", newNamedNonOp("score3"), WithVersion("4")); err == nil {
+		t.Fatal("conflicting versions should fail")
+	}
+
+	cases := map[string]string{
+		"ranking.v2/score@1": "score1",
+		"ranking.v2/score@2": "score2",
+		"ranking.v2/score":   "score2", // the latest version
+	}
+	for id, expected := range cases {
+		if opr := m.GetOperator(id); opr == nil || opr.Name() != expected {
+			t.Fatalf("operator:%s should be %s, got:%v", id, expected, opr)
+		}
+	}
+	if m.GetOperator("ranking.v2/score@5") != nil || m.GetOperator("score") != nil {
+		t.Fatal("unregistered operators shouldn't be found")
+	}
+
+	// the operator registered without version is lower than any version
+	if err := m.RegisterOperator("ranking.v2/score", newNamedNonOp("score0")); err != nil {
+		t.Fatal(err)
+	}
+	if opr := m.GetOperator("ranking.v2/score"); opr.Name() != "score2" {
+		t.Fatalf("unexpected operator:%s", opr.Name())
+	}
+}
+
+func TestDefaultOperatorManager_VersionOrder(t *testing.T) {
+	m := NewDefaultOperatorManager()
+	// registered out of order
+	for _, version := range []string{"1.10.0", "2", "1.9", "1.10", "1.2-beta"} {
+		if err := m.RegisterOperator("score@"+version, newNamedNonOp(version)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if opr := m.GetOperator("score"); opr.Name() != "2" {
+		t.Fatalf("the highest version should be resolved, got:%s", opr.Name())
+	}
+	m2 := NewDefaultOperatorManager()
+	for _, version := range []string{"1.10", "1.10.0", "1.9", "1.2-beta"} {
+		if err := m2.RegisterOperator("score@"+version, newNamedNonOp(version)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if opr := m2.GetOperator("score"); opr.Name() != "1.10.0" {
+		t.Fatalf("the highest version should be resolved, got:%s", opr.Name())
+	}
+
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.10", "1.9", 1},
+		{"1.0.2", "1.0", 1},
+		{"2", "10", -1},
+		{"1.01", "1.1", 0},
+		{"1.2-beta", "1.2-alpha", 1},
+		{"", "0", -1},
+	}
+	for _, c := range cases {
+		if result := compareVersions(c.a, c.b); result*c.expected < 0 || (result == 0) != (c.expected == 0) {
+			t.Fatalf("compare %q with %q, expected:%d, got:%d", c.a, c.b, c.expected, result)
+		}
+	}
+}
+
+func TestDefaultOperatorManager_Alias(t *testing.T) {
+	m := NewDefaultOperatorManager()
+	if err := m.RegisterOperator("ranking/score@1", newNamedNonOp("score1"), WithAliases("score")); err != nil {
+		t.Fatal(err)
+	}
+	if opr := m.GetOperator("score"); opr == nil || opr.Name() != "score1" {
+		t.Fatal("alias should refer to ranking/score@1")
+	}
+	if err := m.Alias("score", "ranking/score"); err == nil {
+		t.Fatal("an alias can't refer to two operators")
+	}
+	if err := m.Alias("rank", "ranking/unknown"); err == nil {
+		t.Fatal("an alias can't refer to an unregistered operator")
+	}
+	if err := m.RegisterOperator("score", newNamedNonOp("score")); err == nil {
+		t.Fatal("an operator can't have the same id with an alias")
+	}
+	if err := m.Alias("s", "score"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaultOperatorManager_RejectDuplicate(t *testing.T) {
+	m := NewDefaultOperatorManager()
+	if err := m.RegisterOperator("opr", newNamedNonOp("opr")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RegisterOperator("opr", newNamedNonOp("opr_replaced")); err != nil {
+		t.Fatal(err)
+	}
+	if m.GetOperator("opr").Name() != "opr_replaced" {
+		t.Fatal("operator should be replaced")
+	}
+	m.SetRejectDuplicate(true)
+	if err := m.RegisterOperator("opr", newNamedNonOp("opr")); err == nil {
+		t.Fatal("duplicated operator should be rejected")
+	} else {
+		t.Log(err)
+	}
+}
+
+func TestDefaultOperatorManager_ListOperators(t *testing.T) {
+	m := NewDefaultOperatorManager()
+	_ = m.RegisterOperator("DataOperator1", func() Operator { return &DataOperator1{} },
+		WithDescription("outputs d1 and d2"))
+	_ = m.RegisterOperator("ns/DataOperator2@1", func() Operator { return &DataOperator2{} })
+	metas := make(map[string]OperatorMeta)
+	for _, meta := range m.ListOperators() {
+		metas[meta.ID()] = meta
+	}
+	if meta := metas["DataOperator1"]; meta.Description != "outputs d1 and d2" ||
+		fmt.Sprint(meta.Outputs) != "[d1 d2]" {
+		t.Fatalf("unexpected meta:%+v", meta)
+	}
+	if meta := metas["ns/DataOperator2@1"]; meta.Name != "ns/DataOperator2" || meta.Namespace != "ns" ||
+		meta.Version != "1" || fmt.Sprint(meta.Inputs) != "[d1 d2]" {
+		t.Fatalf("unexpected meta:%+v", meta)
+	}
+	if meta := metas[BuiltinSleep]; meta.Namespace != "dage" || len(meta.Args["duration_ms"]) == 0 {
+		t.Fatalf("unexpected meta:%+v", meta)
+	}
+
+	// the objects created to read the declarations are closed
+	counter := &lifecycleCounter{}
+	_ = m.RegisterOperator("lifecycleOpr", func() Operator { return newLifecycleOpr(counter, nil) })
+	_ = m.ListOperators()
+	if counter.created.Load() != 1 || counter.probes.Load() != 1 || counter.init.Load() != 0 {
+		t.Fatalf("unexpected created:%d, probes:%d, init:%d", counter.created.Load(), counter.probes.Load(),
+			counter.init.Load())
+	}
+}
+
+func TestDefaultOperatorManager_ConcurrentRegister(t *testing.T) {
+	m := NewDefaultOperatorManager()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				name := fmt.Sprintf("ns%d/opr%d", i, j)
+				_ = m.RegisterOperator(name, newNamedNonOp(name))
+				if m.GetOperator(name) == nil {
+					t.Errorf("operator:%s should be found", name)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
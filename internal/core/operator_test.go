@@ -3,6 +3,9 @@ package core
 import (
 	"fmt"
 	"github.com/MisakiOfScut/go-dage/internal/utils/log"
+	"go.uber.org/atomic"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -123,6 +126,249 @@ func (p *DataOperator2) Reset() Operator {
 	return p
 }
 
+// TypedDataOperator3 declares d1 as a string, which mismatches with the output of DataOperator1
+type TypedDataOperator3 struct {
+	executed bool
+}
+
+func (p *TypedDataOperator3) Name() string {
+	return "TypedDataOperator3"
+}
+func (p *TypedDataOperator3) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	p.executed = true
+	return nil, nil
+}
+func (p *TypedDataOperator3) InjectDepsData(key string, value interface{}) error {
+	return nil
+}
+func (p *TypedDataOperator3) GetInputsID() []string {
+	return []string{"d1"}
+}
+func (p *TypedDataOperator3) GetOutputsID() []string {
+	return nil
+}
+func (p *TypedDataOperator3) GetInputsType() map[string]reflect.Type {
+	return map[string]reflect.Type{"d1": reflect.TypeOf("")}
+}
+func (p *TypedDataOperator3) GetOutputsType() map[string]reflect.Type {
+	return nil
+}
+func (p *TypedDataOperator3) Reset() Operator {
+	return p
+}
+
+// TypedDataOperator4 declares d1 as a float64, and asserts the injected value without converting it
+type TypedDataOperator4 struct {
+	d1       float64
+	executed bool
+}
+
+func (p *TypedDataOperator4) Name() string {
+	return "TypedDataOperator4"
+}
+func (p *TypedDataOperator4) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	p.executed = true
+	return nil, nil
+}
+func (p *TypedDataOperator4) InjectDepsData(key string, value interface{}) error {
+	p.d1 = value.(float64)
+	return nil
+}
+func (p *TypedDataOperator4) GetInputsID() []string {
+	return []string{"d1"}
+}
+func (p *TypedDataOperator4) GetOutputsID() []string {
+	return nil
+}
+func (p *TypedDataOperator4) GetInputsType() map[string]reflect.Type {
+	return map[string]reflect.Type{"d1": reflect.TypeOf(float64(0))}
+}
+func (p *TypedDataOperator4) GetOutputsType() map[string]reflect.Type {
+	return nil
+}
+func (p *TypedDataOperator4) Reset() Operator {
+	return p
+}
+
+// TypedDataOperator5 is DataOperator1 declaring d1 as an int
+type TypedDataOperator5 struct {
+	DataOperator1
+}
+
+func (p *TypedDataOperator5) Name() string {
+	return "TypedDataOperator5"
+}
+func (p *TypedDataOperator5) GetOutputsID() []string {
+	return []string{"d1"}
+}
+func (p *TypedDataOperator5) GetInputsType() map[string]reflect.Type {
+	return nil
+}
+func (p *TypedDataOperator5) GetOutputsType() map[string]reflect.Type {
+	return map[string]reflect.Type{"d1": reflect.TypeOf(0)}
+}
+func (p *TypedDataOperator5) Reset() Operator {
+	return p
+}
+
+// lifecycleOpr counts the calls of its lifecycle hooks
+type lifecycleOpr struct {
+	nonOp
+	initErr     error
+	initialized bool
+	counter     *lifecycleCounter
+}
+
+// lifecycleCounter counts the objects closed after being initialized in close, and the objects only created to read
+// their declarations in probes
+type lifecycleCounter struct {
+	created, init, warmup, close, probes atomic.Int32
+}
+
+func newLifecycleOpr(counter *lifecycleCounter, initErr error) Operator {
+	counter.created.Inc()
+	return &lifecycleOpr{nonOp: nonOp{name: "lifecycleOpr"}, counter: counter, initErr: initErr}
+}
+
+func (p *lifecycleOpr) Init() error {
+	p.counter.init.Inc()
+	p.initialized = true
+	return p.initErr
+}
+func (p *lifecycleOpr) Warmup() error {
+	p.counter.warmup.Inc()
+	return nil
+}
+func (p *lifecycleOpr) Close() error {
+	if p.initialized {
+		p.counter.close.Inc()
+	} else {
+		p.counter.probes.Inc()
+	}
+	return nil
+}
+
+// resetFailedOpr returns a new object from Reset, which fails to be initialized
+type resetFailedOpr struct {
+	nonOp
+	initErr  error
+	executed *atomic.Int32
+}
+
+func (p *resetFailedOpr) Init() error {
+	return p.initErr
+}
+func (p *resetFailedOpr) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	p.executed.Inc()
+	return nil, nil
+}
+func (p *resetFailedOpr) Reset() Operator {
+	return &resetFailedOpr{nonOp: p.nonOp, initErr: fmt.Errorf("connection refused"), executed: p.executed}
+}
+
+// flakyOpr fails its first failures executions
+type flakyOpr struct {
+	nonOp
+	failures int32
+	calls    atomic.Int32
+}
+
+func (p *flakyOpr) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	if p.calls.Inc() <= p.failures {
+		return nil, fmt.Errorf("flaky failure")
+	}
+	return nil, nil
+}
+func (p *flakyOpr) Reset() Operator {
+	return p
+}
+
+// backendOpr fails while its backend is down
+type backendOpr struct {
+	nonOp
+	down *atomic.Bool
+}
+
+func (p *backendOpr) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	if p.down.Load() {
+		return nil, fmt.Errorf("backend is down")
+	}
+	return nil, nil
+}
+func (p *backendOpr) Reset() Operator {
+	return p
+}
+
+// blockOpr blocks until release is closed, ignoring the context of the execution
+type blockOpr struct {
+	nonOp
+	release chan struct{}
+}
+
+func (p *blockOpr) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	<-p.release
+	return nil, nil
+}
+func (p *blockOpr) Reset() Operator {
+	return p
+}
+
+// auditOpr sends the vertex results and the context error of the execution to a channel
+type auditOpr struct {
+	nonOp
+	ch chan string
+}
+
+func (p *auditOpr) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	p.ch <- fmt.Sprintf("%v %v", ctx.VertexResults(), ctx.Context().Err())
+	return nil, nil
+}
+func (p *auditOpr) Reset() Operator {
+	return p
+}
+
+// outcomeOpr reports the outcome it's created with
+type outcomeOpr struct {
+	nonOp
+	outcome string
+}
+
+func (p *outcomeOpr) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	return map[string]interface{}{OutcomeKey: Outcome(p.outcome)}, nil
+}
+func (p *outcomeOpr) GetOutcomes() []string {
+	return []string{"hit", "miss", "stale"}
+}
+func (p *outcomeOpr) Reset() Operator {
+	return p
+}
+
+// recordOpr appends its name and inputs to a trace
+type recordOpr struct {
+	builtinOperator
+	name     string
+	declared []string // declared inputs
+	lock     *sync.Mutex
+	trace    *[]string
+}
+
+func (p *recordOpr) Name() string {
+	return p.name
+}
+func (p *recordOpr) GetInputsID() []string {
+	return p.declared
+}
+func (p *recordOpr) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	*p.trace = append(*p.trace, fmt.Sprintf("%s%v", p.name, p.sortedInputNames(nil)))
+	return nil, nil
+}
+func (p *recordOpr) Reset() Operator {
+	p.reset()
+	return p
+}
+
 func TestDefaultOperatorManager_RegisterOperator(t *testing.T) {
 	for i := 1; i < 15; i++ {
 		name := fmt.Sprintf("opr%d", i)
@@ -149,6 +395,15 @@ func TestDefaultOperatorManager_RegisterOperator(t *testing.T) {
 	tOprMgr.RegisterOperator("DataOperator2", func() Operator {
 		return &DataOperator2{}
 	})
+	tOprMgr.RegisterOperator("TypedDataOperator3", func() Operator {
+		return &TypedDataOperator3{}
+	})
+	tOprMgr.RegisterOperator("TypedDataOperator4", func() Operator {
+		return &TypedDataOperator4{}
+	})
+	tOprMgr.RegisterOperator("TypedDataOperator5", func() Operator {
+		return &TypedDataOperator5{}
+	})
 }
 
 func TestNewDefaultOperatorManager(t *testing.T) {
@@ -0,0 +1,294 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BuiltinSubprocess = "dage/subprocess"
+
+	subprocessStderrLimit = 4096 // the max bytes of stderr kept for error messages
+)
+
+// subprocessOperator runs a command, writes the inputs as a json object to its stdin, and reads the outputs as a json
+// object from its stdout.
+// In worker mode, the command is kept alive and shared by all vertexes running the same command line, every request
+// is a json object in one line, and the command should reply one json object in one line.
+// 	e.x.
+// 		[[graph.vertex]]
+// 		id = "py_score"
+// 		op = "dage/subprocess"
+// 		input = [{name = "features", id = "features"}]
+// 		output = [{name = "score", id = "score"}]
+// 		args = { command = ["python3", "score.py"], timeout_ms = 50, worker = true }
+//
+type subprocessOperator struct {
+	builtinOperator
+	command []string
+	timeout time.Duration
+	worker  bool
+
+	w *subprocessWorker // acquired in Init and released in Close if in worker mode
+}
+
+func (p *subprocessOperator) Name() string {
+	return BuiltinSubprocess
+}
+
+func (p *subprocessOperator) Configure(args map[string]interface{}) error {
+	var err error
+	if p.command, err = argStringSlice(args, "command"); err != nil {
+		return err
+	}
+	if len(p.command) == 0 {
+		return fmt.Errorf("arg command is required")
+	}
+	ms, err := argInt64(args, "timeout_ms", 0)
+	if err != nil {
+		return err
+	}
+	p.timeout = time.Duration(ms) * time.Millisecond
+	p.worker, err = argBool(args, "worker", false)
+	return err
+}
+
+func (p *subprocessOperator) Init() error {
+	if p.worker {
+		p.w = acquireSubprocessWorker(p.command)
+	}
+	return nil
+}
+
+func (p *subprocessOperator) Close() error {
+	if p.w != nil {
+		releaseSubprocessWorker(p.w)
+		p.w = nil
+	}
+	return nil
+}
+
+func (p *subprocessOperator) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	execCtx := ctx.Context()
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithTimeout(execCtx, p.timeout)
+		defer cancel()
+	}
+	inputs := p.inputs
+	if inputs == nil {
+		inputs = map[string]interface{}{}
+	}
+	request, err := json.Marshal(inputs)
+	if err != nil {
+		return nil, fmt.Errorf("marshal inputs failed with err:%v", err)
+	}
+
+	var response []byte
+	if p.w != nil {
+		response, err = p.w.call(execCtx, request)
+	} else {
+		response, err = runSubprocess(execCtx, p.command, request)
+	}
+	if err != nil {
+		return nil, err
+	}
+	outputs := make(map[string]interface{})
+	if err = json.Unmarshal(response, &outputs); err != nil {
+		return nil, fmt.Errorf("command:%v, unmarshal outputs failed with err:%v", p.command, err)
+	}
+	return outputs, nil
+}
+
+func (p *subprocessOperator) Reset() Operator {
+	p.reset()
+	return p
+}
+
+// runSubprocess runs a command once, the command is killed when ctx is done
+func runSubprocess(ctx context.Context, command []string, stdin []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	stdout, stderr := bytes.Buffer{}, limitedBuffer{limit: subprocessStderrLimit}
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("command:%v is killed because of %v, stderr:%s", command, ctx.Err(),
+				stderr.String())
+		}
+		return nil, fmt.Errorf("command:%v failed with err:%v, stderr:%s", command, err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// limitedBuffer keeps the last limit bytes written to it
+type limitedBuffer struct {
+	lock  sync.Mutex
+	buf   []byte
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.limit {
+		b.buf = b.buf[len(b.buf)-b.limit:]
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return strings.TrimSpace(string(b.buf))
+}
+
+var (
+	subprocessWorkersLock sync.Mutex
+	subprocessWorkers     = make(map[string]*subprocessWorker) // map command line to worker
+)
+
+func acquireSubprocessWorker(command []string) *subprocessWorker {
+	key := strings.Join(command, "\x00")
+	subprocessWorkersLock.Lock()
+	defer subprocessWorkersLock.Unlock()
+	w, ok := subprocessWorkers[key]
+	if !ok {
+		w = &subprocessWorker{key: key, command: command, sem: make(chan struct{}, 1)}
+		subprocessWorkers[key] = w
+	}
+	w.refs++
+	return w
+}
+
+// releaseSubprocessWorker stops the worker after its last reference is released. The worker is removed from the
+// workers before waiting for its in-flight request, so acquiring and releasing other workers isn't blocked.
+func releaseSubprocessWorker(w *subprocessWorker) {
+	subprocessWorkersLock.Lock()
+	w.refs--
+	unused := w.refs == 0
+	if unused {
+		delete(subprocessWorkers, w.key)
+	}
+	subprocessWorkersLock.Unlock()
+	if unused {
+		w.sem <- struct{}{}
+		w.stop()
+		<-w.sem
+	}
+}
+
+// subprocessWorker is a long-lived process handling newline-delimited json requests one by one.
+// The process is started on the first request, and restarted on the next request after it exits or is killed.
+type subprocessWorker struct {
+	key     string
+	command []string
+	refs    int // protected by subprocessWorkersLock
+
+	sem    chan struct{} // a semaphore of one permit, which protects the fields below and makes requests serial
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	lines  chan []byte // lines read from stdout, closed when stdout is closed
+	stderr *limitedBuffer
+}
+
+// call sends a request and waits for its response. The request isn't sent if ctx is done when it is waiting for the
+// previous requests.
+func (w *subprocessWorker) call(ctx context.Context, request []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case w.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-w.sem }()
+	// the permit may be acquired along with ctx being done, since select chooses randomly
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if w.cmd == nil {
+		if err := w.start(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := w.stdin.Write(append(request, '\n')); err != nil {
+		return nil, w.fail(fmt.Errorf("write request failed with err:%v", err))
+	}
+	select {
+	case line, ok := <-w.lines:
+		if !ok {
+			return nil, w.fail(errors.New("worker exited"))
+		}
+		return line, nil
+	case <-ctx.Done():
+		return nil, w.fail(fmt.Errorf("worker is killed because of %v", ctx.Err()))
+	}
+}
+
+func (w *subprocessWorker) start() error {
+	cmd := exec.Command(w.command[0], w.command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	w.stderr = &limitedBuffer{limit: subprocessStderrLimit}
+	cmd.Stderr = w.stderr
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("command:%v, start worker failed with err:%v", w.command, err)
+	}
+	w.cmd, w.stdin, w.lines = cmd, stdin, make(chan []byte)
+	go func(lines chan<- []byte) {
+		defer close(lines)
+		reader := bufio.NewReader(stdout)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				lines <- line
+			}
+			if err != nil {
+				return
+			}
+		}
+	}(w.lines)
+	return nil
+}
+
+// fail stops the process and returns an error with the process's stderr
+func (w *subprocessWorker) fail(err error) error {
+	stderr := w.stderr.String()
+	state := w.stop()
+	return fmt.Errorf("command:%v, %v, exit state:%s, stderr:%s", w.command, err, state, stderr)
+}
+
+// stop kills the process and waits for it, returns the exit state
+func (w *subprocessWorker) stop() string {
+	if w.cmd == nil {
+		return ""
+	}
+	_ = w.stdin.Close()
+	_ = w.cmd.Process.Kill()
+	// drain stdout, so that the reading goroutine is able to exit
+	for range w.lines {
+	}
+	_ = w.cmd.Wait()
+	state := w.cmd.ProcessState.String()
+	w.cmd, w.stdin, w.lines = nil, nil, nil
+	return state
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MisakiOfScut/go-dage/internal/utils/executor"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSubprocessOperator(t *testing.T, args map[string]interface{}) *subprocessOperator {
+	p := &subprocessOperator{}
+	if err := p.Configure(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSubprocessOperator_Execute(t *testing.T) {
+	p := newTestSubprocessOperator(t, map[string]interface{}{"command": []interface{}{"cat"}})
+	defer p.Close()
+	_ = p.InjectDepsData("a", 1)
+	_ = p.InjectDepsData("b", "x")
+	outputs, err := p.OnExecute(&DAGContext{})
+	if err != nil || fmt.Sprint(outputs) != "map[a:1 b:x]" {
+		t.Fatalf("unexpected outputs:%v, err:%v", outputs, err)
+	}
+}
+
+func TestSubprocessOperator_ExitStatus(t *testing.T) {
+	p := newTestSubprocessOperator(t, map[string]interface{}{
+		"command": []interface{}{"sh", "-c", "echo boom >&2; exit 3"}})
+	defer p.Close()
+	_, err := p.OnExecute(&DAGContext{})
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("unexpected err:%v", err)
+	}
+}
+
+func TestSubprocessOperator_Timeout(t *testing.T) {
+	p := newTestSubprocessOperator(t, map[string]interface{}{
+		"command": []interface{}{"sleep", "5"}, "timeout_ms": int64(50)})
+	defer p.Close()
+	start := time.Now()
+	_, err := p.OnExecute(&DAGContext{})
+	if err == nil || time.Since(start) > time.Second {
+		t.Fatalf("command should be killed after timeout, err:%v, cost:%v", err, time.Since(start))
+	}
+
+	// the execution's deadline
+	p = newTestSubprocessOperator(t, map[string]interface{}{"command": []interface{}{"sleep", "5"}})
+	defer p.Close()
+	ctx := &DAGContext{}
+	cancel := ctx.setDeadline(time.Now().UnixMilli() + 50)
+	defer cancel()
+	start = time.Now()
+	if _, err = p.OnExecute(ctx); err == nil || time.Since(start) > time.Second {
+		t.Fatalf("command should be killed after deadline, err:%v, cost:%v", err, time.Since(start))
+	}
+}
+
+func TestSubprocessOperator_Worker(t *testing.T) {
+	args := map[string]interface{}{"command": []interface{}{"cat"}, "worker": true}
+	p1 := newTestSubprocessOperator(t, args)
+	p2 := newTestSubprocessOperator(t, args)
+	if p1.w != p2.w {
+		t.Fatal("operators with the same command should share the worker")
+	}
+	for i := 0; i < 3; i++ {
+		_ = p1.Reset().InjectDepsData("i", i)
+		outputs, err := p1.OnExecute(&DAGContext{})
+		if err != nil || fmt.Sprint(outputs) != fmt.Sprintf("map[i:%d]", i) {
+			t.Fatalf("unexpected outputs:%v, err:%v", outputs, err)
+		}
+	}
+	pid := p1.w.cmd.Process.Pid
+	if _, err := p2.OnExecute(&DAGContext{}); err != nil || p2.w.cmd.Process.Pid != pid {
+		t.Fatalf("worker should be reused, err:%v", err)
+	}
+	w := p1.w
+	_ = p1.Close()
+	if w.cmd == nil {
+		t.Fatal("worker should be alive until all operators are closed")
+	}
+	_ = p2.Close()
+	if w.cmd != nil {
+		t.Fatal("worker should be stopped after all operators are closed")
+	}
+}
+
+func TestSubprocessOperator_WorkerRestart(t *testing.T) {
+	// the worker replies the first request, and then exits
+	p := newTestSubprocessOperator(t, map[string]interface{}{
+		"command": []interface{}{"sh", "-c", "read line; echo $line; echo bye >&2; exit 1"}, "worker": true})
+	defer p.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := p.OnExecute(&DAGContext{}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := p.OnExecute(&DAGContext{})
+		if err == nil || !strings.Contains(err.Error(), "bye") {
+			t.Fatalf("unexpected err:%v", err)
+		}
+	}
+}
+
+func TestSubprocessOperator_WorkerDeadline(t *testing.T) {
+	p := newTestSubprocessOperator(t, map[string]interface{}{"command": []interface{}{"cat"}, "worker": true})
+	defer p.Close()
+	if _, err := p.OnExecute(&DAGContext{}); err != nil {
+		t.Fatal(err)
+	}
+	pid := p.w.cmd.Process.Pid
+
+	// the request waiting behind a slow one gives up at its deadline, without killing the worker
+	p.w.sem <- struct{}{}
+	ctx := &DAGContext{}
+	cancel := ctx.setDeadline(time.Now().UnixMilli() + 50)
+	defer cancel()
+	start := time.Now()
+	if _, err := p.OnExecute(ctx); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Fatalf("unexpected err:%v, cost:%v", err, time.Since(start))
+	}
+	<-p.w.sem
+	if _, err := p.OnExecute(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("request shouldn't be sent after the deadline, err:%v", err)
+	}
+	if _, err := p.OnExecute(&DAGContext{}); err != nil || p.w.cmd.Process.Pid != pid {
+		t.Fatalf("worker should be alive, err:%v", err)
+	}
+}
+
+func TestSubprocessOperator_WorkerRelease(t *testing.T) {
+	p := newTestSubprocessOperator(t, map[string]interface{}{"command": []interface{}{"cat"}, "worker": true})
+	if _, err := p.OnExecute(&DAGContext{}); err != nil {
+		t.Fatal(err)
+	}
+	w := p.w
+
+	// closing the last operator waits for the in-flight request, without blocking other workers
+	w.sem <- struct{}{}
+	closed := make(chan struct{})
+	go func() {
+		_ = p.Close()
+		close(closed)
+	}()
+	done := make(chan struct{})
+	go func() {
+		other := newTestSubprocessOperator(t, map[string]interface{}{"command": []interface{}{"tee"}, "worker": true})
+		_ = other.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("releasing a worker shouldn't block the others")
+	}
+	select {
+	case <-closed:
+		t.Fatal("worker should be stopped after the in-flight request")
+	case <-time.After(10 * time.Millisecond):
+	}
+	<-w.sem
+	<-closed
+	if w.cmd != nil {
+		t.Fatal("worker should be stopped after all operators are closed")
+	}
+}
+
+func TestSubprocessOperator_Graph(t *testing.T) {
+	ch := make(chan interface{}, 1)
+	oprMgr := NewDefaultOperatorManager()
+	_ = oprMgr.RegisterOperator("DataOperator1", func() Operator { return &DataOperator1{} })
+	_ = oprMgr.RegisterOperator("captureOpr", func() Operator { return &captureOpr{ch: ch} })
+	m := NewGraphManager(executor.NewDefaultExecutor(32, 8), oprMgr)
+	defer m.Stop()
+	script := `
+[[graph]]
+name = "test_subprocess"
+
+[[graph.vertex]]
+op = "DataOperator1"
+
+[[graph.vertex]]
+id = "to_list"
+op = "dage/subprocess"
+input = [{name = "d1", id = "d1"}]
+output = [{name = "list", id = "list"}]
+args = { command = ["sh", "-c", "sed -u 's/{\"d1\":\\(.*\\)}/{\"list\":[\\1]}/'"], worker = true }
+
+[[graph.vertex]]
+op = "captureOpr"
+`
+	if err := m.Build(graphClusterName, &script); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Execute(nil, graphClusterName, "test_subprocess", 1000, func() {
+		close(ch)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if list := <-ch; fmt.Sprint(list) != "[1]" {
+		t.Fatalf("unexpected list:%v", list)
+	}
+}
@@ -1,10 +1,16 @@
 package core
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/MisakiOfScut/go-dage/internal/script"
 	"github.com/MisakiOfScut/go-dage/internal/utils/eval"
+	"github.com/MisakiOfScut/go-dage/internal/utils/executor"
 	"github.com/MisakiOfScut/go-dage/internal/utils/log"
 	"go.uber.org/atomic"
+	"reflect"
+	"strings"
 	"time"
 )
 
@@ -14,13 +20,37 @@ type vertexContext struct {
 	result                   int
 	remainingDepsNum         atomic.Uint32
 	eval                     eval.EvaluableExpression
+	split                    *script.Split
 	nextVertexCtx            []*vertexContext
-	depsVertexResult         map[string]int // expected result
-	depsVertexesActualResult []int          // store actual result
+	depsVertexResult         map[string]int      // expected result
+	depsVertexesActualResult []int               // store actual result
+	depsVertexOutcome        map[string][]string // expected outcomes of deps vertexes in their next_on
 	depsIdx                  map[string]int
+	args                     map[string]interface{}
 	inputData                []script.Data
 	outputData               []script.Data
 	outputValues             map[string]interface{}
+	timeoutMs                int64  // timeout of every attempt to execute the operator, zero means no timeout
+	retry                    int    // max retry times after the operator failed
+	critical                 bool   // abort the execution if the operator failed
+	finally                  bool   // run after all other vertexes are done
+	skipped                  bool   // not executed in this execution
+	outcome                  string // outcome reported by the operator in this execution
+	setUpErr                 error  // the operator returned by Reset failed to be set up, which fails the vertex
+
+	pool    *executorPool    // the pool executing the vertex
+	inline  bool             // executed in the goroutine completing its deps
+	profile *vertexProfile   // shared by all contexts of the graph
+	limiter *operatorLimiter // shared by all vertexes of the operator, whose limit may be set by later scripts
+	breaker *circuitBreaker  // shared by all vertexes of the operator, nil if the operator has no breaker
+
+	compensateOprID     string
+	compensator         Operator // undoes the side effects of operator, nil if the vertex has no compensate_op
+	compensatorSetUpErr error    // the compensator returned by Reset failed to be set up
+
+	call         OperatorCall // reused in every execution
+	chain        OperatorFunc // operator wrapped by interceptors
+	chainVersion uint64
 
 	graphContext *graphContext
 }
@@ -50,9 +80,35 @@ func (v *vertexContext) setDependencyRes(id string, res int) uint32 {
 	}
 }
 
-func (v *vertexContext) build(vertex *script.Vertex) {
+func (v *vertexContext) build(vertex *script.Vertex) error {
 	if v.operator = v.graphContext.getOprMgr().GetOperator(vertex.Operator); v.operator == nil {
-		log.Panicf("vertex id:%s, can't find its operator:%s in operator manager", vertex.ID, vertex.Operator)
+		return fmt.Errorf("[graph:%s] vertex id:%s, can't find its operator:%s in operator manager",
+			v.graphContext.name, vertex.ID, vertex.Operator)
+	}
+	v.args = vertex.Args
+	v.profile = v.graphContext.profile.get(vertex.ID)
+	if v.pool = v.graphContext.graphClusterCtx.getPool(vertex.Pool); v.pool == nil {
+		return fmt.Errorf("[graph:%s] vertex id:%s, can't find its pool:%s, which should be registered before "+
+			"building", v.graphContext.name, vertex.ID, vertex.Pool)
+	}
+	v.limiter = v.graphContext.graphClusterCtx.getLimiter(vertex.Operator)
+	v.breaker = v.graphContext.graphClusterCtx.getBreaker(vertex.Operator)
+	if err := setUpOperator(v.operator, v.args); err != nil {
+		v.operator = nil
+		return fmt.Errorf("[graph:%s] vertex id:%s, operator:%s %v", v.graphContext.name, vertex.ID,
+			vertex.Operator, err)
+	}
+	if len(vertex.CompensateOp) > 0 {
+		v.compensateOprID = vertex.CompensateOp
+		if v.compensator = v.graphContext.getOprMgr().GetOperator(vertex.CompensateOp); v.compensator == nil {
+			return fmt.Errorf("[graph:%s] vertex id:%s, can't find its compensate_op:%s in operator manager",
+				v.graphContext.name, vertex.ID, vertex.CompensateOp)
+		}
+		if err := setUpOperator(v.compensator, nil); err != nil {
+			v.compensator = nil
+			return fmt.Errorf("[graph:%s] vertex id:%s, compensate_op:%s %v", v.graphContext.name, vertex.ID,
+				vertex.CompensateOp, err)
+		}
 	}
 
 	for id, _ := range vertex.NextVertex {
@@ -66,6 +122,7 @@ func (v *vertexContext) build(vertex *script.Vertex) {
 	}
 
 	v.depsVertexResult = vertex.DepsVertexResult
+	v.depsVertexOutcome = vertex.DepsVertexOutcome
 	v.depsVertexesActualResult = make([]int, len(vertex.DepsVertexResult))
 	v.depsIdx = make(map[string]int)
 	idx := 0
@@ -77,29 +134,113 @@ func (v *vertexContext) build(vertex *script.Vertex) {
 
 	v.id = vertex.ID
 	v.eval = vertex.Eval
+	v.split = vertex.Split
+	// condition and split vertexes are cheap, which are always executed inline
+	v.inline = vertex.Inline || v.pool.isInline() || v.eval != nil || v.split != nil
 	v.result = script.VInit
 	v.outputData = vertex.Output
 	v.inputData = vertex.Input
+	v.timeoutMs = *vertex.TimeoutMs
+	v.retry = *vertex.Retry
+	v.critical = vertex.Critical
+	v.finally = vertex.Finally
+	v.call = OperatorCall{
+		Cluster:  v.graphContext.graphClusterCtx.name,
+		Graph:    v.graphContext.name,
+		VertexID: vertex.ID,
+		OprID:    vertex.Operator,
+		Operator: v.operator,
+		Inputs:   make(map[string]interface{}, len(vertex.Input)),
+	}
 	v.remainingDepsNum.Store(uint32(len(v.depsVertexesActualResult)))
+	return nil
 }
 
-func (v *vertexContext) execute() {
-	defer v.onFinish()
+func (v *vertexContext) warmup() error {
+	if w, ok := v.operator.(Warmer); ok {
+		if err := w.Warmup(); err != nil {
+			return fmt.Errorf("[graph:%s] vertex id:%s, warmup operator:%s failed with err:%v", v.graphContext.name,
+				v.id, v.operator.Name(), err)
+		}
+	}
+	return nil
+}
 
+func (v *vertexContext) close() {
+	if v.compensator != nil {
+		closeOperator(v.compensator)
+		v.compensator = nil
+	}
+	if v.operator == nil {
+		return
+	}
+	closeOperator(v.operator)
+	v.operator = nil
+}
+
+// setUpOperator configures and initializes a newly created operator object
+func setUpOperator(opr Operator, args map[string]interface{}) error {
+	if c, ok := opr.(Configurable); ok {
+		if err := c.Configure(args); err != nil {
+			return fmt.Errorf("configure failed with err:%v", err)
+		}
+	} else if len(args) > 0 {
+		return fmt.Errorf("doesn't accept args")
+	}
+	if i, ok := opr.(Initializer); ok {
+		if err := i.Init(); err != nil {
+			return fmt.Errorf("init failed with err:%v", err)
+		}
+	}
+	return nil
+}
+
+func closeOperator(opr Operator) {
+	if c, ok := opr.(Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Errorf("close operator:%s failed with err:%v", opr.Name(), err)
+		}
+	}
+}
+
+// execute the vertex, graphContext.onVertexDone should be called after it
+func (v *vertexContext) execute() {
+	if v.finally {
+		// finally vertexes are always executed
+		v.executeUserProcessor()
+		return
+	}
+	if v.graphContext.aborted.Load() {
+		log.Debugf("graph:%s execution is aborted, skip vertex:%s", v.graphContext.name, v.id)
+		v.skip()
+		return
+	}
 	// graph execute timeout
-	if v.graphContext.getEndTime() != 0 && v.graphContext.getEndTime() <= time.Now().UnixMilli() {
+	if v.graphContext.isTimeout() {
 		log.Infof("graph:%s execution had %d ms timeout when executing vertex:%s", v.graphContext.name,
 			time.Now().UnixMilli()-v.graphContext.getEndTime(), v.id)
-		v.result = script.VAll
+		v.graphContext.timeout.Store(true)
+		v.skip()
+		return
+	}
+	if err := v.graphContext.context.Context().Err(); err != nil {
+		// the parent context is done
+		log.Infof("graph:%s execution is canceled with err:%v when executing vertex:%s", v.graphContext.name, err,
+			v.id)
+		if errors.Is(err, context.DeadlineExceeded) {
+			v.graphContext.timeout.Store(true)
+		} else {
+			v.graphContext.abort(v.id, err)
+		}
+		v.skip()
 		return
 	}
 	for depVertexId, idx := range v.depsIdx {
 		result := v.depsVertexesActualResult[idx]
 		expected := v.depsVertexResult[depVertexId]
-		if expected == script.VAll {
-			continue
-		} else if expected != result {
+		if (expected != script.VAll && expected != result) || !v.isOutcomeExpected(depVertexId) {
 			v.result = script.VFail
+			v.skipped = true
 			return
 		}
 	}
@@ -111,21 +252,73 @@ func (v *vertexContext) execute() {
 
 	if v.eval != nil {
 		v.executeCondProcessor()
+	} else if v.split != nil {
+		v.executeSplitProcessor()
 	} else {
 		v.executeUserProcessor()
 	}
 }
 
+// isOutcomeExpected reports whether the outcome of a deps vertex is one of the expected outcomes in its next_on
+func (v *vertexContext) isOutcomeExpected(depVertexId string) bool {
+	outcomes, ok := v.depsVertexOutcome[depVertexId]
+	if !ok {
+		return true
+	}
+	actual := v.graphContext.getVertexCtx(depVertexId).outcome
+	for _, outcome := range outcomes {
+		if outcome == actual {
+			return true
+		}
+	}
+	return false
+}
+
+// priority of the vertex in the executor, vertexes on the critical path of the graph have higher priorities
+func (v *vertexContext) priority() executor.Priority {
+	return executor.Priority{Class: v.graphContext.priorityClass, Value: v.profile.criticalPath.Load()}
+}
+
+// skip the vertex, its next vertexes will be executed or skipped as well
+func (v *vertexContext) skip() {
+	v.result = script.VAll
+	v.skipped = true
+}
+
+func (v *vertexContext) getResult() VertexResult {
+	if v.skipped {
+		return VertexSkipped
+	} else if v.result == script.VOk {
+		return VertexOk
+	}
+	return VertexFailed
+}
+
+// getDAGContext returns the context of the execution, finally vertexes have their own context
+func (v *vertexContext) getDAGContext() *DAGContext {
+	if v.finally {
+		return v.graphContext.finallyContext
+	}
+	return v.graphContext.context
+}
+
 func (v *vertexContext) injectData() bool {
 	for i, _ := range v.inputData {
 		if val := v.graphContext.getVertexCtxByData(v.inputData[i].ID).emitData(v.inputData[i].Name); val != nil {
+			if !script.IsDataTypeCompatible(reflect.TypeOf(val), v.inputData[i].Type) {
+				log.Errorf("graph:%s, vertex:%s, with operator:%s, input:%s with data id:%s expects type %s, "+
+					"but got %T", v.graphContext.name, v.id, v.operator.Name(), v.inputData[i].Name,
+					v.inputData[i].ID, v.inputData[i].Type, val)
+				return false
+			}
+			v.call.Inputs[v.inputData[i].Name] = val
 			if err := v.operator.InjectDepsData(v.inputData[i].Name, val); err != nil {
-				log.Errorf("vertex:%s, with operator:%s, injecting input:%+v failed with err:%v", v.id, v.operator.Name,
-					v.inputData[i], err)
+				log.Errorf("graph:%s, vertex:%s, with operator:%s, injecting input:%+v failed with err:%v",
+					v.graphContext.name, v.id, v.operator.Name(), v.inputData[i], err)
 				return false
 			}
 		} else {
-			log.Errorf("vertex:%s, with operator:%s, missed input:%+v", v.id, v.operator.Name, v.inputData[i])
+			log.Errorf("vertex:%s, with operator:%s, missed input:%+v", v.id, v.operator.Name(), v.inputData[i])
 			return false
 		}
 	}
@@ -135,7 +328,7 @@ func (v *vertexContext) injectData() bool {
 func (v *vertexContext) emitData(name string) interface{} {
 	val, existed := v.outputValues[name]
 	if !existed {
-		log.Errorf("vertex:%s, with operator:%s, missed output:%s", v.id, v.operator.Name, name)
+		log.Errorf("vertex:%s, with operator:%s, missed output:%s", v.id, v.operator.Name(), name)
 		return nil
 	}
 	return val
@@ -159,25 +352,217 @@ func (v *vertexContext) executeCondProcessor() {
 	}
 }
 
+// executeSplitProcessor assigns the execution to a bucket, and records the bucket into params and the result
+func (v *vertexContext) executeSplitProcessor() {
+	v.result = script.VFail
+	ctx := v.graphContext.context
+	key, err := ctx.GetParamByName(v.split.Key)
+	if err != nil {
+		log.Errorf("vertex:%s, get split key:%s failed with err:%v", v.id, v.split.Key, err)
+		return
+	}
+	bucket := v.split.Bucket(key)
+	if err = ctx.SetParams(v.split.Param, bucket); err != nil {
+		log.Errorf("vertex:%s, set split param:%s failed with err:%v", v.id, v.split.Param, err)
+		return
+	}
+	v.outcome = bucket
+	v.graphContext.setBucket(v.id, bucket)
+	v.result = script.VOk
+}
+
 func (v *vertexContext) executeUserProcessor() {
-	var err error
-	if v.outputValues, err = v.operator.OnExecute(v.graphContext.context); err != nil {
+	if v.setUpErr != nil {
 		v.result = script.VFail
-		log.Errorf("vertex:%s, with operator:%s, execution return err:%v", v.id, v.operator.Name, err)
+		log.Errorf("[graph:%s] vertex id:%s, skip the operator failed to be set up, err:%v", v.graphContext.name,
+			v.id, v.setUpErr)
 		return
 	}
-	v.result = script.VOk
+	interceptors := v.graphContext.graphClusterCtx.getInterceptors()
+	if v.chain == nil || v.chainVersion != interceptors.version.Load() {
+		v.chain, v.chainVersion = interceptors.chain(v.call.Cluster, v.call.OprID)
+	}
+	v.call.Operator = v.operator
+
+	var err error
+	start := time.Now()
+	defer func() {
+		v.profile.observe(time.Since(start))
+	}()
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := v.newCallContext()
+		v.call.Ctx = ctx
+		v.outputValues, err = v.callOperator()
+		cancel()
+		if err == nil {
+			v.result = script.VOk
+			v.takeOutcome()
+			return
+		}
+		if attempt >= v.retry || errors.Is(err, ErrAbortGraph) || errors.Is(err, ErrCircuitOpen) ||
+			(!v.finally && (v.graphContext.isCanceled() || v.graphContext.aborted.Load())) {
+			break
+		}
+		log.Warnf("vertex:%s, with operator:%s, execution return err:%v, retry:%d/%d", v.id, v.operator.Name(),
+			err, attempt+1, v.retry)
+	}
+	v.result = script.VFail
+	if errors.Is(err, ErrCircuitOpen) {
+		v.outcome = CircuitOpenOutcome
+	}
+	log.Errorf("vertex:%s, with operator:%s, execution return err:%v", v.id, v.operator.Name(), err)
+	v.graphContext.onOperatorFailed(v, err)
+}
+
+// callOperator calls the operator through the interceptors within the limit and the circuit breaker of the operator
+func (v *vertexContext) callOperator() (map[string]interface{}, error) {
+	var generation uint64
+	if v.breaker != nil {
+		var err error
+		if generation, err = v.breaker.allow(); err != nil {
+			return nil, err
+		}
+	}
+	if held, err := v.limiter.acquire(v.call.Ctx.Context()); err != nil {
+		if v.breaker != nil {
+			v.breaker.cancel(generation)
+		}
+		return nil, err
+	} else if held {
+		defer v.limiter.release()
+	}
+	outputs, err := v.chain(&v.call)
+	if v.breaker != nil {
+		v.breaker.done(generation, err)
+	}
+	return outputs, err
+}
+
+// takeOutcome moves the outcome reported by the operator out of its outputs
+func (v *vertexContext) takeOutcome() {
+	val, ok := v.outputValues[OutcomeKey]
+	if !ok {
+		return
+	}
+	delete(v.outputValues, OutcomeKey)
+	switch outcome := val.(type) {
+	case Outcome:
+		v.outcome = string(outcome)
+	case string:
+		v.outcome = outcome
+	default:
+		log.Errorf("vertex:%s, with operator:%s, outcome:%v isn't a string", v.id, v.operator.Name(), val)
+	}
+}
+
+// newCallContext returns the context passed to the operator, which is done when the vertex is timeout
+func (v *vertexContext) newCallContext() (*DAGContext, context.CancelFunc) {
+	if v.timeoutMs <= 0 {
+		return v.getDAGContext(), func() {}
+	}
+	ctx := *v.getDAGContext()
+	cancel := ctx.setDeadline(time.Now().UnixMilli() + v.timeoutMs)
+	return &ctx, cancel
+}
+
+// compensate executes the compensating operator with the inputs and outputs of the vertex injected. Only the inputs
+// declared by the compensating operator are injected, or all of them if it declares none like builtin operators.
+// The data which is both an input and an output of the vertex can't be injected, since they are ambiguous.
+func (v *vertexContext) compensate(ctx *DAGContext) error {
+	if v.compensatorSetUpErr != nil {
+		return v.compensatorSetUpErr
+	}
+	names := v.compensator.GetInputsID()
+	if len(names) == 0 {
+		// all data of the vertex is injected, the data with the same name is injected as an input and an output
+		names = make([]string, 0, len(v.call.Inputs)+len(v.outputValues))
+		for name := range v.call.Inputs {
+			if _, ok := v.outputValues[name]; ok {
+				names = append(names, script.COMPENSATE_INPUT_PREFIX+name, script.COMPENSATE_OUTPUT_PREFIX+name)
+			} else {
+				names = append(names, name)
+			}
+		}
+		for name := range v.outputValues {
+			if _, ok := v.call.Inputs[name]; !ok {
+				names = append(names, name)
+			}
+		}
+	}
+	call := OperatorCall{
+		Cluster:  v.call.Cluster,
+		Graph:    v.call.Graph,
+		VertexID: v.id,
+		OprID:    v.compensateOprID,
+		Operator: v.compensator,
+		Inputs:   make(map[string]interface{}, len(names)),
+		Ctx:      ctx,
+	}
+	for _, name := range names {
+		var value interface{}
+		var ok bool
+		if strings.HasPrefix(name, script.COMPENSATE_INPUT_PREFIX) {
+			value, ok = v.call.Inputs[strings.TrimPrefix(name, script.COMPENSATE_INPUT_PREFIX)]
+		} else if strings.HasPrefix(name, script.COMPENSATE_OUTPUT_PREFIX) {
+			value, ok = v.outputValues[strings.TrimPrefix(name, script.COMPENSATE_OUTPUT_PREFIX)]
+		} else {
+			input, isInput := v.call.Inputs[name]
+			output, isOutput := v.outputValues[name]
+			switch {
+			case isInput && isOutput:
+				// the output isn't declared by the operator, otherwise building fails
+				return fmt.Errorf("data:%s is both an input and an output of the vertex, which should be declared "+
+					"as %s%s or %s%s", name, script.COMPENSATE_INPUT_PREFIX, name, script.COMPENSATE_OUTPUT_PREFIX,
+					name)
+			case isInput:
+				value, ok = input, true
+			case isOutput:
+				value, ok = output, true
+			}
+		}
+		if !ok {
+			continue
+		}
+		call.Inputs[name] = value
+		if err := v.compensator.InjectDepsData(name, value); err != nil {
+			return fmt.Errorf("injecting data:%s failed with err:%v", name, err)
+		}
+	}
+	chain, _ := v.graphContext.graphClusterCtx.getInterceptors().chain(v.call.Cluster, v.compensateOprID)
+	_, err := chain(&call)
+	return err
 }
 
-func (v *vertexContext) onFinish() {
-	v.graphContext.onVertexDone(v)
+// resetOperator resets an operator object, and returns the object for the next execution along with the error of
+// setting it up. An object failed to be set up keeps failing until Reset returns a new one.
+func (v *vertexContext) resetOperator(opr Operator, args map[string]interface{}, setUpErr error) (Operator, error) {
+	newOpr := opr.Reset()
+	if newOpr == opr {
+		return newOpr, setUpErr
+	}
+	// Reset returned a new object, so the previous one is discarded
+	closeOperator(opr)
+	if err := setUpOperator(newOpr, args); err != nil {
+		log.Errorf("[graph:%s] vertex id:%s, operator:%s %v", v.graphContext.name, v.id, newOpr.Name(), err)
+		return newOpr, fmt.Errorf("operator:%s %v", newOpr.Name(), err)
+	}
+	return newOpr, nil
 }
 
 func (v *vertexContext) reset() {
 	v.result = script.VInit
+	v.skipped = false
+	v.outcome = ""
 	v.remainingDepsNum.Store(uint32(len(v.depsVertexesActualResult)))
-	v.operator = v.operator.Reset()
+	v.operator, v.setUpErr = v.resetOperator(v.operator, v.args, v.setUpErr)
+	if v.compensator != nil {
+		v.compensator, v.compensatorSetUpErr = v.resetOperator(v.compensator, nil, v.compensatorSetUpErr)
+	}
 	v.outputValues = nil
+	v.call.Ctx = nil
+	for k := range v.call.Inputs {
+		delete(v.call.Inputs, k)
+	}
 	for k, _ := range v.depsVertexesActualResult {
 		v.depsVertexesActualResult[k] = script.VInit
 	}
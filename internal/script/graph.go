@@ -2,11 +2,13 @@ package script
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 )
 
 type GraphCluster struct {
 	Graph []Graph `toml:"graph"`
+	Limit []Limit `toml:"limit"`
 
 	isBuild  bool
 	graphMgr IGraphManager
@@ -17,6 +19,9 @@ type IGraphManager interface {
 	IsOprExisted(oprName string) bool
 	GetOperatorInputs(oprName string) []string
 	GetOperatorOutputs(oprName string) []string
+	GetOperatorInputTypes(oprName string) map[string]reflect.Type  // nil if the operator doesn't declare types
+	GetOperatorOutputTypes(oprName string) map[string]reflect.Type // nil if the operator doesn't declare types
+	GetOperatorOutcomes(oprName string) []string                   // outcome labels declared by the operator
 	IsProduction() bool
 }
 
@@ -25,6 +30,16 @@ func NewGraphCluster(gMgr IGraphManager) *GraphCluster {
 }
 
 func (gc *GraphCluster) Build() error {
+	limited := make(map[string]bool, len(gc.Limit))
+	for i := 0; i < len(gc.Limit); i++ {
+		if err := gc.Limit[i].setUp(gc.graphMgr); err != nil {
+			return err
+		}
+		if limited[gc.Limit[i].Operator] {
+			return fmt.Errorf("limit of operator:%s is duplicated", gc.Limit[i].Operator)
+		}
+		limited[gc.Limit[i].Operator] = true
+	}
 	for i := 0; i < len(gc.Graph); i++ {
 		g := &gc.Graph[i]
 		if gc.graphMap[g.Name] != nil {
@@ -76,12 +91,26 @@ type Graph struct {
 	Name   string   `toml:"name"`
 	Vertex []Vertex `toml:"vertex"`
 
+	// TimeoutMs is the timeout of an execution, which is overridden by a positive timeout passed to Execute.
+	TimeoutMs int64 `toml:"timeout_ms"`
+	// DefaultVertexTimeoutMs and DefaultRetry are used by vertexes without timeout_ms or retry.
+	DefaultVertexTimeoutMs int64 `toml:"default_vertex_timeout_ms"`
+	DefaultRetry           int   `toml:"default_retry"`
+	// FailFast makes an execution skip the remaining vertexes once an operator failed.
+	FailFast bool `toml:"fail_fast"`
+	// Pool is the executor pool of vertexes without pool, the default pool of the engine is used if it's empty.
+	Pool string `toml:"pool"`
+
 	cluster       *GraphCluster
 	vertexMap     map[string]*Vertex // map vertex id to *Vertex
 	OutputDataMap map[string]*Vertex // map output data id to *Vertex
 }
 
 func (g *Graph) build() error {
+	if g.TimeoutMs < 0 || g.DefaultVertexTimeoutMs < 0 || g.DefaultRetry < 0 {
+		return fmt.Errorf("[graph:%s] timeout_ms, default_vertex_timeout_ms and default_retry shouldn't be negative",
+			g.Name)
+	}
 	if g.vertexMap == nil {
 		g.vertexMap = make(map[string]*Vertex)
 	}
@@ -1,7 +1,9 @@
 package script
 
 import (
+	"fmt"
 	"github.com/BurntSushi/toml"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -53,6 +55,15 @@ func (p *mockGraphManager) GetOperatorInputs(oprName string) []string {
 func (p *mockGraphManager) GetOperatorOutputs(oprName string) []string {
 	return nil
 }
+func (p *mockGraphManager) GetOperatorInputTypes(oprName string) map[string]reflect.Type {
+	return nil
+}
+func (p *mockGraphManager) GetOperatorOutputTypes(oprName string) map[string]reflect.Type {
+	return nil
+}
+func (p *mockGraphManager) GetOperatorOutcomes(oprName string) []string {
+	return nil
+}
 func (p *mockGraphManager) IsProduction() bool {
 	return false
 }
@@ -237,3 +248,405 @@ func TestCondExprParse(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// typedGraphManager declares that producer outputs an int and consumer expects a string
+type typedGraphManager struct {
+	mockGraphManager
+}
+
+func (p *typedGraphManager) GetOperatorInputs(oprName string) []string {
+	if oprName == "consumer" {
+		return []string{"d1"}
+	}
+	return nil
+}
+func (p *typedGraphManager) GetOperatorOutputs(oprName string) []string {
+	if oprName == "producer" {
+		return []string{"d1"}
+	}
+	return nil
+}
+func (p *typedGraphManager) GetOperatorInputTypes(oprName string) map[string]reflect.Type {
+	if oprName == "consumer" {
+		return map[string]reflect.Type{"d1": reflect.TypeOf("")}
+	}
+	return nil
+}
+func (p *typedGraphManager) GetOperatorOutputTypes(oprName string) map[string]reflect.Type {
+	if oprName == "producer" {
+		return map[string]reflect.Type{"d1": reflect.TypeOf(0)}
+	}
+	return nil
+}
+func (p *typedGraphManager) IsProduction() bool {
+	return true
+}
+
+func TestDataTypeCheck(t *testing.T) {
+	var testDataType = `
+[[graph]]
+name = "test_data_type"
+
+[[graph.vertex]]
+op = "producer"
+
+[[graph.vertex]]
+op = "consumer"
+`
+	gc := NewGraphCluster(&typedGraphManager{})
+	if _, err := toml.Decode(testDataType, gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Build(); err == nil {
+		t.Fail()
+	} else {
+		t.Log(err)
+	}
+}
+
+func TestIsDataTypeCompatible(t *testing.T) {
+	// numeric types aren't converted by operators implementing TypedDataOperator themselves
+	if IsDataTypeCompatible(reflect.TypeOf(1), reflect.TypeOf(int64(1))) {
+		t.Fail()
+	}
+	if IsDataTypeCompatible(reflect.TypeOf(float64(1)), reflect.TypeOf(float32(1))) {
+		t.Fail()
+	}
+	if !IsDataTypeCompatible(nil, reflect.TypeOf("")) {
+		t.Fail()
+	}
+	if !IsDataTypeCompatible(reflect.TypeOf(&strings.Builder{}), reflect.TypeOf((*fmt.Stringer)(nil)).Elem()) {
+		t.Fail()
+	}
+	if IsDataTypeCompatible(reflect.TypeOf(1), reflect.TypeOf("")) {
+		t.Fail()
+	}
+}
+
+func TestDumpDotWithOperatorNamespace(t *testing.T) {
+	var testNamespace = `
+[[graph]]
+name = "test_namespace"
+
+[[graph.vertex]]
+op = "ranking.v2/score@1"
+start = true
+next = ["ranking.v2/sort"]
+
+[[graph.vertex]]
+op = "ranking.v2/sort"
+`
+	gc := NewGraphCluster(&mockGraphManager{})
+	if _, err := toml.Decode(testNamespace, gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Build(); err != nil {
+		t.Fatal(err)
+	}
+	sb := strings.Builder{}
+	gc.DumpGraphClusterDot(&sb)
+	if !strings.Contains(sb.String(), "test_namespace_ranking_v2_score_1 -> test_namespace_ranking_v2_sort") {
+		t.Fatal(sb.String())
+	}
+}
+
+func TestGraphSettings(t *testing.T) {
+	var testGraphSettings = `
+[[graph]]
+name = "test_graph_0"
+timeout_ms = 100
+default_vertex_timeout_ms = 20
+default_retry = 2
+fail_fast = true
+
+[[graph.vertex]]
+op = "op1"
+start = true
+next = ["op2"]
+
+[[graph.vertex]]
+op = "op2"
+timeout_ms = 50
+retry = 1
+next = ["op3"]
+
+[[graph.vertex]]
+op = "op3"
+timeout_ms = 0
+retry = 0
+`
+	gc := NewGraphCluster(&mockGraphManager{})
+	if _, err := toml.Decode(testGraphSettings, gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Build(); err != nil {
+		t.Fatal(err)
+	}
+	g := gc.GetGraphByName("test_graph_0")
+	if g.TimeoutMs != 100 || !g.FailFast {
+		t.Fatalf("unexpected graph settings:%+v", g)
+	}
+	if v := g.GetVertexByID("op1"); *v.TimeoutMs != 20 || *v.Retry != 2 {
+		t.Fatalf("vertex should use the default settings, timeout_ms:%d, retry:%d", *v.TimeoutMs, *v.Retry)
+	}
+	if v := g.GetVertexByID("op2"); *v.TimeoutMs != 50 || *v.Retry != 1 {
+		t.Fatalf("vertex should override the default settings, timeout_ms:%d, retry:%d", *v.TimeoutMs, *v.Retry)
+	}
+	if v := g.GetVertexByID("op3"); *v.TimeoutMs != 0 || *v.Retry != 0 {
+		t.Fatalf("explicit zeros should opt out of the default settings, timeout_ms:%d, retry:%d", *v.TimeoutMs,
+			*v.Retry)
+	}
+
+	gc = NewGraphCluster(&mockGraphManager{})
+	if _, err := toml.Decode(strings.Replace(testGraphSettings, "retry = 1", "retry = -1", 1), gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Build(); err == nil {
+		t.Fatal("negative retry should fail")
+	} else {
+		t.Log(err)
+	}
+}
+
+func TestFinallyVertex(t *testing.T) {
+	var testFinally = `
+[[graph]]
+name = "test_graph_0"
+
+[[graph.vertex]]
+op = "op1"
+start = true
+
+[[graph.vertex]]
+op = "unlock"
+finally = true
+`
+	gc := NewGraphCluster(&mockGraphManager{})
+	if _, err := toml.Decode(testFinally, gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Build(); err != nil {
+		t.Fatal(err)
+	}
+	sb := strings.Builder{}
+	gc.DumpGraphClusterDot(&sb)
+	if !strings.Contains(sb.String(), "test_graph_0_unlock -> test_graph_0__STOP__ [style=dotted];") {
+		t.Fatalf("unexpected dot:%s", sb.String())
+	}
+
+	gc = NewGraphCluster(&mockGraphManager{})
+	if _, err := toml.Decode(testFinally+`deps = ["op1"]`, gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Build(); err == nil {
+		t.Fatal("finally vertex with deps should fail")
+	} else {
+		t.Log(err)
+	}
+}
+
+// outcomeGraphManager declares that cache reports hit, miss or stale
+type outcomeGraphManager struct {
+	mockGraphManager
+}
+
+func (p *outcomeGraphManager) GetOperatorOutcomes(oprName string) []string {
+	if oprName == "cache" {
+		return []string{"hit", "miss", "stale"}
+	}
+	return nil
+}
+func (p *outcomeGraphManager) IsProduction() bool {
+	return true
+}
+
+func TestNextOnOutcome(t *testing.T) {
+	var testOutcome = `
+[[graph]]
+name = "test_graph_0"
+
+[[graph.vertex]]
+op = "cache"
+start = true
+next_on = { hit = ["render"], miss = ["load"], stale = ["load"] }
+
+[[graph.vertex]]
+op = "load"
+
+[[graph.vertex]]
+op = "render"
+`
+	gc := NewGraphCluster(&outcomeGraphManager{})
+	if _, err := toml.Decode(testOutcome, gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Build(); err != nil {
+		t.Fatal(err)
+	}
+	load := gc.GetGraphByName("test_graph_0").GetVertexByID("load")
+	if outcomes := load.DepsVertexOutcome["cache"]; len(outcomes) != 2 || outcomes[0] != "miss" ||
+		outcomes[1] != "stale" {
+		t.Fatalf("unexpected outcomes:%v", outcomes)
+	}
+	sb := strings.Builder{}
+	gc.DumpGraphClusterDot(&sb)
+	if !strings.Contains(sb.String(), "test_graph_0_cache -> test_graph_0_load [style=dashed color=blue "+
+		"label=\"miss|stale\"];") {
+		t.Fatalf("unexpected dot:%s", sb.String())
+	}
+
+	gc = NewGraphCluster(&outcomeGraphManager{})
+	if _, err := toml.Decode(strings.Replace(testOutcome, "stale =", "expired =", 1), gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Build(); err == nil {
+		t.Fatal("undeclared outcome should fail")
+	} else {
+		t.Log(err)
+	}
+
+	// the reserved outcome needn't be declared, and the vertexes routed on it don't expect the vertex to be ok
+	gc = NewGraphCluster(&outcomeGraphManager{})
+	if _, err := toml.Decode(strings.Replace(testOutcome, "stale =", CIRCUIT_OPEN_OUTCOME+" =", 1), gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Build(); err != nil {
+		t.Fatal(err)
+	}
+	graph := gc.GetGraphByName("test_graph_0")
+	if graph.GetVertexByID("load").DepsVertexResult["cache"] != VAll ||
+		graph.GetVertexByID("render").DepsVertexResult["cache"] != VOk {
+		t.Fatalf("unexpected deps of load:%v, render:%v", graph.GetVertexByID("load").DepsVertexResult,
+			graph.GetVertexByID("render").DepsVertexResult)
+	}
+}
+
+func TestSplitVertex(t *testing.T) {
+	var testSplit = `
+[[graph]]
+name = "test_graph_0"
+
+[[graph.vertex]]
+id = "exp"
+split = { key = "user_id", seed = 7, buckets = { control = 50, treatment_a = 25, treatment_b = 25 } }
+start = true
+next_on = { control = ["rank_v1"], treatment_a = ["rank_v2"], treatment_b = ["rank_v3"] }
+
+[[graph.vertex]]
+op = "rank_v1"
+
+[[graph.vertex]]
+op = "rank_v2"
+
+[[graph.vertex]]
+op = "rank_v3"
+`
+	gc := NewGraphCluster(&mockGraphManager{})
+	if _, err := toml.Decode(testSplit, gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Build(); err != nil {
+		t.Fatal(err)
+	}
+	split := gc.GetGraphByName("test_graph_0").GetVertexByID("exp").Split
+	if split.Param != "exp" || fmt.Sprint(split.BucketNames()) != "[control treatment_a treatment_b]" {
+		t.Fatalf("unexpected split:%+v", split)
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		bucket := split.Bucket(i)
+		if split.Bucket(i) != bucket {
+			t.Fatalf("assignment of %d isn't deterministic", i)
+		}
+		counts[bucket]++
+	}
+	if counts["control"] < 4500 || counts["control"] > 5500 || counts["treatment_a"] < 2000 ||
+		counts["treatment_b"] < 2000 {
+		t.Fatalf("unexpected distribution:%v", counts)
+	}
+	seeded := *split
+	seeded.Seed = 8
+	changed := 0
+	for i := 0; i < 100; i++ {
+		if seeded.Bucket(i) != split.Bucket(i) {
+			changed++
+		}
+	}
+	if changed == 0 {
+		t.Fatal("seed doesn't change the assignment")
+	}
+
+	for name, s := range map[string]string{
+		"unknown bucket": strings.Replace(testSplit, "treatment_b = [", "treatment_c = [", 1),
+		"zero weight":    strings.Replace(testSplit, "treatment_b = 25", "treatment_b = 0", 1),
+		"with operator":  strings.Replace(testSplit, `id = "exp"`, `id = "exp"`+"\nop = \"rank_v1\"", 1),
+	} {
+		gc = NewGraphCluster(&mockGraphManager{})
+		if _, err := toml.Decode(s, gc); err != nil {
+			t.Fatal(err)
+		}
+		if err := gc.Build(); err == nil {
+			t.Fatalf("%s should fail", name)
+		} else {
+			t.Log(err)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	var testLimit = `
+[[limit]]
+op = "fetch"
+max_concurrency = 4
+rate = "5/ms"
+policy = "fail_fast"
+
+[[limit]]
+op = "rank"
+rate = " 600 / m "
+
+[[graph]]
+name = "test_graph_0"
+
+[[graph.vertex]]
+op = "fetch"
+start = true
+next = ["rank"]
+
+[[graph.vertex]]
+op = "rank"
+`
+	gc := NewGraphCluster(&mockGraphManager{})
+	if _, err := toml.Decode(testLimit, gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if l := gc.Limit[0]; l.MaxConcurrency != 4 || l.PerSecond() != 5000 || l.Policy != LimitPolicyFailFast {
+		t.Fatalf("unexpected limit:%+v", l)
+	}
+	if l := gc.Limit[1]; l.MaxConcurrency != 0 || l.PerSecond() != 10 || l.Policy != LimitPolicyQueue {
+		t.Fatalf("unexpected limit:%+v", l)
+	}
+
+	for name, s := range map[string]string{
+		"no op":            strings.Replace(testLimit, `op = "fetch"`+"\nmax", "max", 1),
+		"duplicated":       strings.Replace(testLimit, `op = "rank"`+"\nrate", `op = "fetch"`+"\nrate", 1),
+		"negative":         strings.Replace(testLimit, "max_concurrency = 4", "max_concurrency = -4", 1),
+		"unknown policy":   strings.Replace(testLimit, "fail_fast", "drop", 1),
+		"unknown unit":     strings.Replace(testLimit, "5/ms", "5/d", 1),
+		"no number":        strings.Replace(testLimit, "5/ms", "/ms", 1),
+		"without per unit": strings.Replace(testLimit, "5/ms", "5", 1),
+	} {
+		gc = NewGraphCluster(&mockGraphManager{})
+		if _, err := toml.Decode(s, gc); err != nil {
+			t.Fatal(err)
+		}
+		if err := gc.Build(); err == nil {
+			t.Fatalf("%s should fail", name)
+		} else {
+			t.Log(err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package script
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	LimitPolicyQueue    = "queue"     // vertexes wait for the limit until their timeout
+	LimitPolicyFailFast = "fail_fast" // vertexes fail immediately when the limit is hit
+)
+
+// Limit throttles an operator in all executions of the engine until the graph cluster is replaced. The graph clusters
+// limiting the same operator should set the same limit.
+// 	e.x.
+// 		[[limit]]
+// 		op = "fetch_profile"
+// 		max_concurrency = 16
+// 		rate = "100/s"
+// 		policy = "fail_fast"
+//
+type Limit struct {
+	Operator       string `toml:"op"`
+	MaxConcurrency int    `toml:"max_concurrency"` // zero means unlimited
+	Rate           string `toml:"rate"`            // e.x. "100/s", "5/ms" or "600/m", empty means unlimited
+	Policy         string `toml:"policy"`          // LimitPolicyQueue by default
+
+	perSecond float64
+}
+
+func (l *Limit) setUp(graphMgr IGraphManager) error {
+	if len(l.Operator) == 0 {
+		return fmt.Errorf("limit has no op")
+	}
+	if graphMgr.IsProduction() && !graphMgr.IsOprExisted(l.Operator) {
+		return fmt.Errorf("limit of operator:%s, can't find the operator", l.Operator)
+	}
+	if l.MaxConcurrency < 0 {
+		return fmt.Errorf("limit of operator:%s, max_concurrency shouldn't be negative", l.Operator)
+	}
+	if len(l.Policy) == 0 {
+		l.Policy = LimitPolicyQueue
+	} else if l.Policy != LimitPolicyQueue && l.Policy != LimitPolicyFailFast {
+		return fmt.Errorf("limit of operator:%s, unknown policy:%s", l.Operator, l.Policy)
+	}
+	if len(l.Rate) == 0 {
+		return nil
+	}
+	perSecond, err := ParseRate(l.Rate)
+	if err != nil {
+		return fmt.Errorf("limit of operator:%s, %v", l.Operator, err)
+	}
+	l.perSecond = perSecond
+	return nil
+}
+
+// PerSecond returns the rate in permits per second, zero if the rate is unlimited.
+func (l *Limit) PerSecond() float64 {
+	return l.perSecond
+}
+
+// ParseRate parses a rate like "100/s" into permits per second, the unit can be ms, s, m or h.
+func ParseRate(rate string) (float64, error) {
+	idx := strings.LastIndex(rate, "/")
+	if idx < 0 {
+		return 0, fmt.Errorf("rate:%s should be like \"100/s\"", rate)
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(rate[:idx]), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("rate:%s should have a positive number", rate)
+	}
+	var unit time.Duration
+	switch strings.TrimSpace(rate[idx+1:]) {
+	case "ms":
+		unit = time.Millisecond
+	case "s":
+		unit = time.Second
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	default:
+		return 0, fmt.Errorf("rate:%s has an unknown unit, which should be ms, s, m or h", rate)
+	}
+	return n * float64(time.Second) / float64(unit), nil
+}
@@ -0,0 +1,68 @@
+package script
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Split assigns executions to weighted buckets by hashing a param, the vertexes in next_on of a bucket are executed
+// if the execution is assigned to it.
+// 	e.x.
+// 		split = { key = "user_id", buckets = { control = 50, treatment_a = 25, treatment_b = 25 } }
+// 		next_on = { control = ["rank_v1"], treatment_a = ["rank_v2"], treatment_b = ["rank_v3"] }
+//
+type Split struct {
+	Key     string           `toml:"key"`     // the param being hashed
+	Buckets map[string]int64 `toml:"buckets"` // map bucket name to its weight
+	Seed    int64            `toml:"seed"`    // the same key is assigned to another bucket with another seed
+	Param   string           `toml:"param"`   // the param recording the assigned bucket, the vertex id by default
+
+	names  []string // sorted bucket names
+	bounds []uint64 // bounds[i] is the sum of weights of names[0..i]
+}
+
+func (s *Split) setUp() error {
+	if len(s.Key) == 0 {
+		return fmt.Errorf("split key is empty")
+	}
+	if len(s.Buckets) == 0 {
+		return fmt.Errorf("split has no buckets")
+	}
+	s.names = make([]string, 0, len(s.Buckets))
+	for name, weight := range s.Buckets {
+		if weight <= 0 {
+			return fmt.Errorf("weight of split bucket:%s should be positive", name)
+		}
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+	s.bounds = make([]uint64, len(s.names))
+	var total uint64
+	for i, name := range s.names {
+		total += uint64(s.Buckets[name])
+		s.bounds[i] = total
+	}
+	return nil
+}
+
+// BucketNames returns the sorted names of buckets.
+func (s *Split) BucketNames() []string {
+	return s.names
+}
+
+// Bucket returns the bucket which the value of the key param is assigned to, the assignment is deterministic for the
+// same value, seed and buckets.
+func (s *Split) Bucket(value interface{}) string {
+	h := fnv.New64a()
+	seed := make([]byte, 8)
+	binary.LittleEndian.PutUint64(seed, uint64(s.Seed))
+	_, _ = h.Write(seed)
+	_, _ = h.Write([]byte(fmt.Sprint(value)))
+	n := h.Sum64() % s.bounds[len(s.bounds)-1]
+	i := sort.Search(len(s.bounds), func(i int) bool {
+		return n < s.bounds[i]
+	})
+	return s.names[i]
+}
@@ -3,6 +3,8 @@ package script
 import (
 	"fmt"
 	"github.com/MisakiOfScut/go-dage/internal/utils/eval"
+	"reflect"
+	"sort"
 	"strings"
 )
 
@@ -14,12 +16,34 @@ const (
 )
 
 const (
-	DAGE_EXPR_OPERATOR string = "__DAGE_EXPR_OPERATOR__"
+	DAGE_EXPR_OPERATOR  string = "__DAGE_EXPR_OPERATOR__"
+	DAGE_SPLIT_OPERATOR string = "__DAGE_SPLIT_OPERATOR__"
 )
 
+// A compensating operator gets the input and the output of its vertex having the same name, e.x. a value rewritten in
+// place, by declaring the name with these prefixes, e.x. "input:items" and "output:items".
+const (
+	COMPENSATE_INPUT_PREFIX  string = "input:"
+	COMPENSATE_OUTPUT_PREFIX string = "output:"
+)
+
+// CIRCUIT_OPEN_OUTCOME is the reserved outcome of a vertex failed because the circuit breaker of its operator is open,
+// which is routed by next_on without being declared by the operator, e.x. next_on = { circuit_open = ["fallback"] }.
+const CIRCUIT_OPEN_OUTCOME string = "circuit_open"
+
 type Data struct {
-	Name string `toml:"name"` // data name
-	ID   string `toml:"id"`   // data id (id equals to name by default)
+	Name string       `toml:"name"` // data name
+	ID   string       `toml:"id"`   // data id (id equals to name by default)
+	Type reflect.Type `toml:"-"`    // data type declared by operator, nil means unknown
+}
+
+// IsDataTypeCompatible reports whether a value of type from can be passed to an input of type to, which requires
+// from to be assignable to to. Unknown (nil) types are compatible with any type.
+func IsDataTypeCompatible(from reflect.Type, to reflect.Type) bool {
+	if from == nil || to == nil {
+		return true
+	}
+	return from.AssignableTo(to)
 }
 
 type Vertex struct {
@@ -28,10 +52,19 @@ type Vertex struct {
 	Start    bool   `toml:"start"`
 	// Expected string `toml:"expected"`
 	Cond string `toml:"cond"`
+	// Split makes a split vertex, which routes executions to the next_on of weighted buckets
+	Split *Split `toml:"split"`
 
 	Next       []string `toml:"next"`
 	NextOnOk   []string `toml:"next_on_ok"`
 	NextOnFail []string `toml:"next_on_fail"`
+	// NextOn maps an outcome label declared by the operator, or CIRCUIT_OPEN_OUTCOME, to the vertexes executed on the
+	// outcome
+	// 	e.x.
+	// 		next_on = { hit = ["render"], miss = ["load", "fill_cache"], stale = ["refresh"] }
+	//
+	NextOn map[string][]string `toml:"next_on"`
+
 	Deps       []string `toml:"deps"`
 	DepsOnOk   []string `toml:"deps_on_ok"`
 	DepsOnFail []string `toml:"deps_on_fail"`
@@ -39,13 +72,51 @@ type Vertex struct {
 	Input  []Data `toml:"input"`
 	Output []Data `toml:"output"`
 
+	Args map[string]interface{} `toml:"args"` // passed to operators implementing core.Configurable
+
+	// TimeoutMs and Retry are set to default_vertex_timeout_ms and default_retry of the graph if they are unset, an
+	// explicit zero means no timeout or no retry even if the graph has the defaults.
+	TimeoutMs *int64 `toml:"timeout_ms"`
+	Retry     *int   `toml:"retry"`
+	Critical  bool   `toml:"critical"` // the execution is aborted once the operator of a critical vertex failed
+	// Pool is the name of the executor pool executing the vertex, pool of the graph is used if it is empty.
+	// The reserved pool "inline" executes the vertex in the goroutine completing its deps, which suits cheap vertexes.
+	Pool string `toml:"pool"`
+	// Weight is the estimated cost of the vertex in milliseconds, which prioritizes the vertexes on the critical path
+	// of the graph. The observed latency of the vertex is used if it's zero.
+	Weight int64 `toml:"weight"`
+	// Inline executes the vertex in the goroutine completing its deps like the inline pool, but the vertex is
+	// counted in its own pool. Condition and split vertexes are always executed inline.
+	Inline bool `toml:"inline"`
+	// Finally vertexes run after all other vertexes are done or skipped, even if the execution is timeout or aborted.
+	// They can't have any dependencies or inputs.
+	Finally bool `toml:"finally"`
+	// CompensateOp is the operator undoing the side effects of the vertex. If the execution isn't ok, the compensating
+	// operators of all succeeded vertexes are executed in reverse topological order, with the inputs and outputs of
+	// their vertexes injected if they are declared by the compensating operators. A name which is both an input and
+	// an output is injected with COMPENSATE_INPUT_PREFIX and COMPENSATE_OUTPUT_PREFIX.
+	CompensateOp string `toml:"compensate_op"`
+
 	NextVertex       map[string]*Vertex
 	DepsVertexResult map[string]int
-	Eval             eval.EvaluableExpression
-	g                *Graph
+	// DepsVertexOutcome maps a deps vertex's id to the expected outcome labels, any of them is satisfying
+	DepsVertexOutcome map[string][]string
+	Eval              eval.EvaluableExpression
+	g                 *Graph
 }
 
 func (v *Vertex) verifyAndSetUp() error {
+	if v.TimeoutMs == nil {
+		timeoutMs := v.g.DefaultVertexTimeoutMs
+		v.TimeoutMs = &timeoutMs
+	}
+	if v.Retry == nil {
+		retry := v.g.DefaultRetry
+		v.Retry = &retry
+	}
+	if v.Split != nil {
+		return v.setUpSplit()
+	}
 	if len(v.Operator) != 0 && len(v.Cond) != 0 {
 		return fmt.Errorf("[graph:%s] vertex id:%s operator:%s cond:%s, "+
 			"a vertex can't have operator and cond at the same time", v.g.Name, v.ID, v.Operator, v.Cond)
@@ -54,11 +125,23 @@ func (v *Vertex) verifyAndSetUp() error {
 		return fmt.Errorf("[graph:%s] has an anonymous vertex, there are one or more "+
 			"normal vertexes haven't operator (or one or more condition vertexes haven't ID)", v.g.Name)
 	}
+	if *v.TimeoutMs < 0 || *v.Retry < 0 || v.Weight < 0 {
+		return fmt.Errorf("[graph:%s] vertex id:%s operator:%s, timeout_ms, retry and weight shouldn't be negative",
+			v.g.Name, v.ID, v.Operator)
+	}
+	if len(v.Pool) == 0 {
+		v.Pool = v.g.Pool
+	}
 	v.NextVertex = make(map[string]*Vertex)
 	v.DepsVertexResult = make(map[string]int)
+	v.DepsVertexOutcome = make(map[string][]string)
 
 	// cond vertex
 	if len(v.Operator) == 0 && len(v.Cond) != 0 {
+		if len(v.CompensateOp) != 0 {
+			return fmt.Errorf("[graph:%s] vertex id:%s cond:%s, a condition vertex can't have compensate_op",
+				v.g.Name, v.ID, v.Cond)
+		}
 		if len(v.ID) == 0 {
 			return fmt.Errorf("[graph:%s] has a anonymous condition vertex, which must have an ID", v.g.Name)
 		}
@@ -81,6 +164,30 @@ func (v *Vertex) verifyAndSetUp() error {
 	return v.setUpInputOutput()
 }
 
+func (v *Vertex) setUpSplit() error {
+	if len(v.Operator) != 0 || len(v.Cond) != 0 || len(v.CompensateOp) != 0 || v.Finally {
+		return fmt.Errorf("[graph:%s] vertex id:%s, a split vertex can't have operator, cond or compensate_op, "+
+			"and can't be a finally vertex", v.g.Name, v.ID)
+	}
+	if len(v.ID) == 0 {
+		return fmt.Errorf("[graph:%s] has a anonymous split vertex, which must have an ID", v.g.Name)
+	}
+	if err := v.Split.setUp(); err != nil {
+		return fmt.Errorf("[graph:%s, vertex id:%s] %v", v.g.Name, v.ID, err)
+	}
+	if len(v.Split.Param) == 0 {
+		v.Split.Param = v.ID
+	}
+	if len(v.Pool) == 0 {
+		v.Pool = v.g.Pool
+	}
+	v.NextVertex = make(map[string]*Vertex)
+	v.DepsVertexResult = make(map[string]int)
+	v.DepsVertexOutcome = make(map[string][]string)
+	v.Operator = DAGE_SPLIT_OPERATOR
+	return nil
+}
+
 func (v *Vertex) setUpInputOutput() error {
 	if !v.g.GetGraphMgr().IsProduction() {
 		return nil
@@ -89,6 +196,9 @@ func (v *Vertex) setUpInputOutput() error {
 	if !v.g.GetGraphMgr().IsOprExisted(v.Operator) {
 		return fmt.Errorf("vertex id:%s, can't find its operator:%s in operator manager", v.ID, v.Operator)
 	}
+	if len(v.CompensateOp) != 0 && !v.g.GetGraphMgr().IsOprExisted(v.CompensateOp) {
+		return fmt.Errorf("vertex id:%s, can't find its compensate_op:%s in operator manager", v.ID, v.CompensateOp)
+	}
 
 	for _, name := range v.g.GetGraphMgr().GetOperatorInputs(v.Operator) {
 		isMatch := false
@@ -115,9 +225,52 @@ func (v *Vertex) setUpInputOutput() error {
 			v.Output = append(v.Output, Data{Name: name, ID: name})
 		}
 	}
+
+	inputTypes := v.g.GetGraphMgr().GetOperatorInputTypes(v.Operator)
+	for i, _ := range v.Input {
+		v.Input[i].Type = inputTypes[v.Input[i].Name]
+	}
+	outputTypes := v.g.GetGraphMgr().GetOperatorOutputTypes(v.Operator)
+	for i, _ := range v.Output {
+		v.Output[i].Type = outputTypes[v.Output[i].Name]
+	}
+	return v.verifyCompensateOpInputs()
+}
+
+// check that the compensating operator doesn't declare a name which is both an input and an output of the vertex,
+// which should be declared with COMPENSATE_INPUT_PREFIX or COMPENSATE_OUTPUT_PREFIX
+func (v *Vertex) verifyCompensateOpInputs() error {
+	if len(v.CompensateOp) == 0 {
+		return nil
+	}
+	for _, name := range v.g.GetGraphMgr().GetOperatorInputs(v.CompensateOp) {
+		if v.hasInput(name) && v.hasOutput(name) {
+			return fmt.Errorf("vertex id:%s, data:%s is both an input and an output of the vertex, compensate_op:%s "+
+				"should declare %s%s or %s%s instead", v.ID, name, v.CompensateOp, COMPENSATE_INPUT_PREFIX, name,
+				COMPENSATE_OUTPUT_PREFIX, name)
+		}
+	}
 	return nil
 }
 
+func (v *Vertex) hasInput(name string) bool {
+	for i, _ := range v.Input {
+		if v.Input[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Vertex) hasOutput(name string) bool {
+	for i, _ := range v.Output {
+		if v.Output[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (v *Vertex) depend(pre *Vertex, expectedResult int) {
 	// if v.DepsVertexResult == nil {
 	// 	v.DepsVertexResult = make(map[string]int)
@@ -168,6 +321,38 @@ func (v *Vertex) build() error {
 				v.ID, nextVertexID)
 		}
 	}
+	if len(v.NextOn) > 0 {
+		if err := v.verifyOutcomes(); err != nil {
+			return err
+		}
+	}
+	outcomes := make([]string, 0, len(v.NextOn))
+	for outcome := range v.NextOn {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Strings(outcomes) // keep the labels of edges in order
+	// the vertex fails with CIRCUIT_OPEN_OUTCOME, so the vertexes routed on it don't expect the vertex to be ok
+	onCircuitOpen := make(map[string]bool)
+	if v.Split == nil {
+		for _, nextVertexID := range v.NextOn[CIRCUIT_OPEN_OUTCOME] {
+			onCircuitOpen[nextVertexID] = true
+		}
+	}
+	for _, outcome := range outcomes {
+		for _, nextVertexID := range v.NextOn[outcome] {
+			if nextVertex := v.g.GetVertexByID(nextVertexID); nextVertex != nil {
+				if onCircuitOpen[nextVertexID] {
+					nextVertex.depend(v, VAll)
+				} else {
+					nextVertex.depend(v, VOk)
+				}
+				nextVertex.DepsVertexOutcome[v.ID] = append(nextVertex.DepsVertexOutcome[v.ID], outcome)
+			} else {
+				return fmt.Errorf("[graph:%s, vertex id:%s] in vertex's next_on.%s array, id:%s is not existed",
+					v.g.Name, v.ID, outcome, nextVertexID)
+			}
+		}
+	}
 	for _, preVertexID := range v.Deps {
 		if preVertex := v.g.GetVertexByID(preVertexID); preVertex != nil {
 			v.depend(preVertex, VAll)
@@ -196,9 +381,50 @@ func (v *Vertex) build() error {
 	return nil
 }
 
+// check that the outcomes in next_on are declared by the operator or reserved, or are the buckets of the split vertex
+func (v *Vertex) verifyOutcomes() error {
+	if v.Operator == DAGE_EXPR_OPERATOR {
+		return fmt.Errorf("[graph:%s, vertex id:%s] a condition vertex can't have next_on", v.g.Name, v.ID)
+	}
+	var declared []string
+	if v.Split != nil {
+		declared = v.Split.BucketNames()
+	} else if v.g.GetGraphMgr().IsProduction() {
+		declared = v.g.GetGraphMgr().GetOperatorOutcomes(v.Operator)
+	} else {
+		return nil
+	}
+	for outcome := range v.NextOn {
+		if outcome == CIRCUIT_OPEN_OUTCOME && v.Split == nil {
+			continue
+		}
+		isDeclared := false
+		for _, d := range declared {
+			if d == outcome {
+				isDeclared = true
+				break
+			}
+		}
+		if !isDeclared {
+			return fmt.Errorf("[graph:%s, vertex id:%s] outcome:%s in next_on isn't declared by operator:%s, "+
+				"declared outcomes:%v", v.g.Name, v.ID, outcome, v.Operator, declared)
+		}
+	}
+	return nil
+}
+
 func (v *Vertex) verifyAfterBuild() error {
 	// 1. start vertex shouldn't have deps
 	// 2. non-start vertex shouldn't be isolated
+	// 3. finally vertex should be isolated
+	if v.Finally {
+		if v.Start || len(v.Cond) > 0 || len(v.Input) > 0 || len(v.DepsVertexResult) > 0 || len(v.NextVertex) > 0 ||
+			len(v.CompensateOp) > 0 {
+			return fmt.Errorf("[graph:%s, vertex id:%s] a finally vertex can't be a start or condition vertex, "+
+				"and can't have inputs, deps or compensate_op", v.g.Name, v.ID)
+		}
+		return nil
+	}
 	if v.Start {
 		if len(v.DepsVertexResult) > 0 {
 			return fmt.Errorf("[graph:%s, vertex id:%s] a start vertex shouldn't have deps vertex, "+
@@ -211,6 +437,25 @@ func (v *Vertex) verifyAfterBuild() error {
 			v.g.Name,
 			v.ID)
 	}
+	return v.verifyDataType()
+}
+
+// check that every input's type is compatible with the type of the output it comes from
+func (v *Vertex) verifyDataType() error {
+	for i, _ := range v.Input {
+		preVertex := v.g.getVertexByDataId(v.Input[i].ID)
+		for j, _ := range preVertex.Output {
+			out := &preVertex.Output[j]
+			if out.ID != v.Input[i].ID {
+				continue
+			}
+			if !IsDataTypeCompatible(out.Type, v.Input[i].Type) {
+				return fmt.Errorf("[graph:%s, vertex id:%s] input:%s with data id:%s expects type %s, "+
+					"but vertex:%s outputs %s", v.g.Name, v.ID, v.Input[i].Name, v.Input[i].ID, v.Input[i].Type,
+					preVertex.ID, out.Type)
+			}
+		}
+	}
 	return nil
 }
 
@@ -219,9 +464,14 @@ func (v *Vertex) verifyAfterBuild() error {
 // sub_graph2_test_34old [label="user_type==\"34old\"" shape=diamond color=black fillcolor=aquamarine style=filled];
 func (v *Vertex) dumpNodeDot(sb *strings.Builder) {
 	sb.WriteString(fmt.Sprintf("%s [", v.getDotID()))
-	if len(v.Cond) > 0 {
+	if v.Finally {
+		sb.WriteString(fmt.Sprintf("label=\"%s\" color=black fillcolor=lightgrey style=\"filled,dashed\"", v.ID))
+	} else if len(v.Cond) > 0 {
 		sb.WriteString(fmt.Sprintf("label=\"%s\" shape=diamond color=black fillcolor=aquamarine style=filled",
 			strings.ReplaceAll(v.Cond, "\"", "\\\"")))
+	} else if v.Split != nil {
+		sb.WriteString(fmt.Sprintf("label=\"%s\\nsplit by %s\" shape=trapezium color=black fillcolor=khaki "+
+			"style=filled", v.ID, v.Split.Key))
 	} else {
 		sb.WriteString(fmt.Sprintf("label=\"%s\" color=black fillcolor=linen style=filled", v.ID))
 	}
@@ -229,6 +479,11 @@ func (v *Vertex) dumpNodeDot(sb *strings.Builder) {
 }
 
 func (v *Vertex) dumpEdgeDot(sb *strings.Builder) {
+	if v.Finally {
+		// sub_graph2_unlock -> sub_graph2__STOP__ [style=dotted];
+		sb.WriteString(fmt.Sprintf("%s -> %s__STOP__ [style=dotted];\n", v.getDotID(), v.g.Name))
+		return
+	}
 	if len(v.DepsVertexResult) == 0 {
 		// sub_graph2__START__ -> sub_graph2_opr0;
 		sb.WriteString(fmt.Sprintf("%s__START__ -> %s;\n", v.g.Name, v.getDotID()))
@@ -239,6 +494,11 @@ func (v *Vertex) dumpEdgeDot(sb *strings.Builder) {
 	}
 	for preID, expected := range v.DepsVertexResult {
 		sb.WriteString(fmt.Sprintf("%s -> %s ", v.g.GetVertexByID(preID).getDotID(), v.getDotID()))
+		if outcomes, ok := v.DepsVertexOutcome[preID]; ok {
+			// sub_graph2_cache -> sub_graph2_load [style=dashed color=blue label="miss|stale"];
+			sb.WriteString(fmt.Sprintf("[style=dashed color=blue label=\"%s\"];\n", strings.Join(outcomes, "|")))
+			continue
+		}
 		switch expected {
 		case VOk:
 			// sub_graph2_test_34old -> sub_graph2_opr3 [style=dashed label="ok"];
@@ -253,6 +513,13 @@ func (v *Vertex) dumpEdgeDot(sb *strings.Builder) {
 	}
 }
 
+// dot ids only consist of letters, digits and underscores, while vertex ids may contain operator namespaces and
+// versions, e.x. "ranking.v2/score@1.0.2"
 func (v *Vertex) getDotID() string {
-	return v.g.Name + "_" + v.ID
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, v.g.Name+"_"+v.ID)
 }
@@ -1,54 +1,404 @@
 package executor
 
 import (
+	"context"
+	"errors"
+	"go.uber.org/atomic"
 	"sync"
+	"time"
+)
+
+var (
+	// ErrRejected is returned when a task is shed because the executor is saturated.
+	ErrRejected = errors.New("executor is saturated, the task is rejected")
+	// ErrExecutorStopped is returned when a task is submitted after the executor is stopped.
+	ErrExecutorStopped = errors.New("executor is stopped")
 )
 
 type Executor interface {
-	Execute(func())
+	// Execute submits a task, and handles it with the saturation policy if the queue is full.
+	Execute(task func()) error
+	// Submit is like Execute, but gives up waiting for the queue and returns ctx.Err() once ctx is done.
+	Submit(ctx context.Context, task func()) error
+	// TrySubmit submits a task without blocking, and returns ErrRejected if the queue is full.
+	TrySubmit(task func()) error
 	Stop()
 }
 
+// SaturationPolicy decides how to handle a task submitted when the queue is full.
+type SaturationPolicy int
+
+const (
+	PolicyBlock      SaturationPolicy = iota // wait until the queue has a free slot
+	PolicyReject                             // return ErrRejected
+	PolicyCallerRuns                         // run the task in the goroutine submitting it
+	PolicyGrow                               // run the task in a temporary goroutine, see WithMaxTemporaryWorkers
+)
+
+type Option func(b *executorBase)
+
+// WithSaturationPolicy sets the saturation policy, which is PolicyCallerRuns by default, so that a worker submitting
+// tasks to the full queue can't deadlock by waiting for itself.
+func WithSaturationPolicy(policy SaturationPolicy) Option {
+	return func(b *executorBase) {
+		b.policy = policy
+	}
+}
+
+// WithMaxTemporaryWorkers limits the number of temporary goroutines of PolicyGrow, tasks are rejected when all of
+// them are busy. The limit equals to the concurrentLevel by default.
+func WithMaxTemporaryWorkers(n uint) Option {
+	return func(b *executorBase) {
+		b.maxTemporaryWorkers = int32(n)
+	}
+}
+
+// WithAutoscaling makes the workers of the default executor scale between min and max. A worker is added when a
+// task has waited in the queue longer than targetWait, and a worker exits after being idle for idleTimeout. The
+// concurrentLevel is the initial number of workers, which is clamped between min and max.
+func WithAutoscaling(min uint, max uint, targetWait time.Duration, idleTimeout time.Duration) Option {
+	return func(b *executorBase) {
+		if min == 0 {
+			min = 1
+		}
+		if max < min {
+			max = min
+		}
+		b.autoscaling = &autoscaling{min: int(min), max: int(max), targetWait: targetWait, idleTimeout: idleTimeout}
+	}
+}
+
+type autoscaling struct {
+	min         int
+	max         int
+	targetWait  time.Duration
+	idleTimeout time.Duration
+}
+
+// clamp the number of workers between min and max
+func (a *autoscaling) clamp(n int) int {
+	if n < a.min {
+		return a.min
+	}
+	if n > a.max {
+		return a.max
+	}
+	return n
+}
+
+// executorBase holds the options and the goroutines shared by executor implementations
+type executorBase struct {
+	policy              SaturationPolicy
+	maxTemporaryWorkers int32
+	temporaryWorkers    atomic.Int32
+	autoscaling         *autoscaling   // nil if the workers don't scale
+	wg                  sync.WaitGroup // workers and temporary workers
+}
+
+func (b *executorBase) init(concurrentLevel uint, opts []Option) {
+	b.policy = PolicyCallerRuns
+	b.maxTemporaryWorkers = int32(concurrentLevel)
+	for _, opt := range opts {
+		opt(b)
+	}
+}
+
+// runTemporarily runs a task in a temporary goroutine, which exits after the task is done
+func (b *executorBase) runTemporarily(task func()) error {
+	if b.temporaryWorkers.Inc() > b.maxTemporaryWorkers {
+		b.temporaryWorkers.Dec()
+		return ErrRejected
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer b.temporaryWorkers.Dec()
+		task()
+	}()
+	return nil
+}
+
+// ResizableExecutor is an optional interface of Executor, whose workers can be resized at runtime.
+type ResizableExecutor interface {
+	Executor
+	// Resize sets the number of workers, which is clamped between the limits of autoscaling if it's enabled
+	Resize(n uint) error
+	Stats() Stats
+}
+
+// Stats is the runtime statistics of an executor.
+type Stats struct {
+	Workers    int           // the number of workers
+	Busy       int           // workers executing tasks
+	QueueDepth int           // tasks waiting in the queue
+	WaitTime   time.Duration // the EWMA of the time tasks waited in the queue
+}
+
+type queuedTask struct {
+	task     func()
+	enqueued time.Time
+}
+
 type DefaultExecutorImpl struct {
-	queue  chan func()
-	closed bool
-	wg     sync.WaitGroup
+	executorBase
+	queue    chan queuedTask
+	lock     sync.RWMutex  // held by submissions and resizing for reading and by Stop for writing
+	closed   bool          // guarded by lock
+	stopped  chan struct{} // closed when Stop is called, which releases the blocked submissions
+	stopOnce sync.Once
+
+	workerLock sync.Mutex      // guards workers
+	workers    []chan struct{} // the quit channels of workers, the last ones quit first when shrinking
+	busy       atomic.Int32
+	waitTime   atomic.Int64 // in nanoseconds
+	dequeued   atomic.Int64 // the unix nanoseconds of the latest dequeue
 }
 
-func NewDefaultExecutor(queueLength uint, concurrentLevel uint) Executor {
+func NewDefaultExecutor(queueLength uint, concurrentLevel uint, opts ...Option) Executor {
 	if concurrentLevel == 0 {
 		concurrentLevel = 1
 	}
 
 	d := DefaultExecutorImpl{
-		queue:  make(chan func(), queueLength),
-		closed: false,
+		queue:   make(chan queuedTask, queueLength),
+		closed:  false,
+		stopped: make(chan struct{}),
 	}
-	for i := concurrentLevel; i > 0; i-- {
+	d.init(concurrentLevel, opts)
+	_ = d.Resize(concurrentLevel)
+	if d.autoscaling != nil {
 		d.wg.Add(1)
-		go func() {
-			defer d.wg.Done()
-			for w := range d.queue {
-				// TODO(misaki): add a timeout control
-				if w != nil {
-					w()
+		go d.monitor()
+	}
+	return &d
+}
+
+// monitor adds workers when the queue isn't dequeued for targetWait, because all workers are blocked
+func (d *DefaultExecutorImpl) monitor() {
+	defer d.wg.Done()
+	interval := d.autoscaling.targetWait
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if len(d.queue) > 0 && time.Since(time.Unix(0, d.dequeued.Load())) > d.autoscaling.targetWait {
+				d.grow()
+			}
+		case <-d.stopped:
+			return
+		}
+	}
+}
+
+func (d *DefaultExecutorImpl) work(quit chan struct{}) {
+	defer d.wg.Done()
+	// idle is nil if the workers don't scale, which never fires
+	var idle <-chan time.Time
+	var timer *time.Timer
+	if d.autoscaling != nil {
+		timer = time.NewTimer(d.autoscaling.idleTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+	for {
+		select {
+		case w, ok := <-d.queue:
+			if !ok {
+				return
+			}
+			d.run(w)
+		case <-quit:
+			return
+		case <-idle:
+			if d.retire(quit) {
+				return
+			}
+		}
+		if timer != nil {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
 				}
 			}
-		}()
+			timer.Reset(d.autoscaling.idleTimeout)
+		}
 	}
-	return &d
 }
 
-func (d *DefaultExecutorImpl) Execute(task func()) {
-	if !d.closed {
-		d.queue <- task
+func (d *DefaultExecutorImpl) run(w queuedTask) {
+	// TODO(misaki): add a timeout control
+	if w.task == nil {
+		return
+	}
+	now := time.Now()
+	d.dequeued.Store(now.UnixNano())
+	wait := now.Sub(w.enqueued)
+	// the EWMA weights the new sample by 1/8
+	if previous := d.waitTime.Load(); previous > 0 {
+		d.waitTime.Store(previous + (int64(wait)-previous)/8)
+	} else {
+		d.waitTime.Store(int64(wait))
+	}
+	if d.autoscaling != nil && wait > d.autoscaling.targetWait {
+		d.grow()
+	}
+	d.busy.Inc()
+	w.task()
+	d.busy.Dec()
+}
+
+// startWorker starts a worker, the workerLock should be held
+func (d *DefaultExecutorImpl) startWorker() {
+	quit := make(chan struct{})
+	d.workers = append(d.workers, quit)
+	d.wg.Add(1)
+	go d.work(quit)
+}
+
+// grow adds a worker unless the number of workers reaches the max of autoscaling
+func (d *DefaultExecutorImpl) grow() {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.closed {
+		return
+	}
+	d.workerLock.Lock()
+	defer d.workerLock.Unlock()
+	if len(d.workers) < d.autoscaling.max {
+		d.startWorker()
 	}
 }
 
-// Stop the executor after processing the remaining tasks in the queue. After calling this function,
-// you shouldn't call Execute func, which will cause panic.
+// retire makes an idle worker quit unless the number of workers reaches the min of autoscaling
+func (d *DefaultExecutorImpl) retire(quit chan struct{}) bool {
+	d.workerLock.Lock()
+	defer d.workerLock.Unlock()
+	if len(d.workers) <= d.autoscaling.min {
+		return false
+	}
+	for i, w := range d.workers {
+		if w == quit {
+			d.workers = append(d.workers[:i], d.workers[i+1:]...)
+			return true
+		}
+	}
+	// it has been removed by Resize, and quits in the next loop
+	return false
+}
+
+// Resize sets the number of workers. The quitting workers finish their running tasks, and the tasks in the queue are
+// executed by the remaining ones.
+func (d *DefaultExecutorImpl) Resize(n uint) error {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.closed {
+		return ErrExecutorStopped
+	}
+	size := int(n)
+	if size == 0 {
+		size = 1
+	}
+	if d.autoscaling != nil {
+		size = d.autoscaling.clamp(size)
+	}
+	d.workerLock.Lock()
+	defer d.workerLock.Unlock()
+	for len(d.workers) < size {
+		d.startWorker()
+	}
+	for len(d.workers) > size {
+		close(d.workers[len(d.workers)-1])
+		d.workers = d.workers[:len(d.workers)-1]
+	}
+	return nil
+}
+
+func (d *DefaultExecutorImpl) Stats() Stats {
+	d.workerLock.Lock()
+	workers := len(d.workers)
+	d.workerLock.Unlock()
+	return Stats{
+		Workers:    workers,
+		Busy:       int(d.busy.Load()),
+		QueueDepth: len(d.queue),
+		WaitTime:   time.Duration(d.waitTime.Load()),
+	}
+}
+
+func (d *DefaultExecutorImpl) Execute(task func()) error {
+	return d.Submit(context.Background(), task)
+}
+
+func (d *DefaultExecutorImpl) Submit(ctx context.Context, task func()) error {
+	d.lock.RLock()
+	if d.closed {
+		d.lock.RUnlock()
+		return ErrExecutorStopped
+	}
+	select {
+	case d.queue <- queuedTask{task: task, enqueued: time.Now()}:
+		d.lock.RUnlock()
+		return nil
+	default:
+	}
+	switch d.policy {
+	case PolicyReject:
+		d.lock.RUnlock()
+		return ErrRejected
+	case PolicyCallerRuns:
+		// release the lock before running the task, which may submit other tasks
+		d.lock.RUnlock()
+		task()
+		return nil
+	case PolicyGrow:
+		defer d.lock.RUnlock()
+		return d.runTemporarily(task)
+	}
+	defer d.lock.RUnlock()
+	select {
+	case d.queue <- queuedTask{task: task, enqueued: time.Now()}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-d.stopped:
+		return ErrExecutorStopped
+	}
+}
+
+func (d *DefaultExecutorImpl) TrySubmit(task func()) error {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.closed {
+		return ErrExecutorStopped
+	}
+	select {
+	case d.queue <- queuedTask{task: task, enqueued: time.Now()}:
+		return nil
+	default:
+		return ErrRejected
+	}
+}
+
+// Stop the executor after processing the remaining tasks in the queue. Tasks submitted after calling this function
+// are rejected with ErrExecutorStopped. It's safe to call Stop more than once.
 func (d *DefaultExecutorImpl) Stop() {
-	d.closed = true
-	close(d.queue)
+	// release the blocked submissions first, otherwise they hold the lock
+	d.stopOnce.Do(func() {
+		close(d.stopped)
+	})
+	d.lock.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.lock.Unlock()
 	d.wg.Wait()
+	d.workerLock.Lock()
+	d.workers = nil
+	d.workerLock.Unlock()
 }
@@ -1,9 +1,12 @@
 package executor
 
 import (
+	"context"
 	"fmt"
+	"go.uber.org/atomic"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestCreateExecutor(t *testing.T) {
@@ -37,3 +40,303 @@ func TestConcurrentExecute(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+// saturate blocks all workers and fills the queue of an executor created with 1 queueLength and 1 concurrentLevel,
+// the returned channel releases them
+func saturate(t *testing.T, e Executor) chan struct{} {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := e.Execute(func() {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := e.TrySubmit(func() { <-release }); err != nil {
+		t.Fatal(err)
+	}
+	return release
+}
+
+func TestSaturationPolicy(t *testing.T) {
+	e := NewDefaultExecutor(1, 1, WithSaturationPolicy(PolicyReject))
+	release := saturate(t, e)
+	if err := e.TrySubmit(func() {}); err != ErrRejected {
+		t.Fatalf("unexpected err:%v", err)
+	}
+	if err := e.Execute(func() {}); err != ErrRejected {
+		t.Fatalf("unexpected err:%v", err)
+	}
+	close(release)
+	e.Stop()
+
+	// PolicyCallerRuns is the default policy
+	e = NewDefaultExecutor(1, 1)
+	release = saturate(t, e)
+	ran := false
+	if err := e.Execute(func() { ran = true }); err != nil || !ran {
+		t.Fatalf("task should run in the caller, err:%v", err)
+	}
+	close(release)
+	e.Stop()
+
+	e = NewDefaultExecutor(1, 1, WithSaturationPolicy(PolicyGrow), WithMaxTemporaryWorkers(1))
+	release = saturate(t, e)
+	done := make(chan struct{})
+	if err := e.Execute(func() {
+		<-release
+		close(done)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Execute(func() {}); err != ErrRejected {
+		t.Fatalf("unexpected err:%v", err)
+	}
+	close(release)
+	<-done
+	e.Stop()
+
+	e = NewDefaultExecutor(1, 1, WithSaturationPolicy(PolicyBlock))
+	release = saturate(t, e)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := e.Submit(ctx, func() {}); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected err:%v", err)
+	}
+	close(release)
+	e.Stop()
+}
+
+func TestStop(t *testing.T) {
+	e := NewDefaultExecutor(1, 1, WithSaturationPolicy(PolicyBlock))
+	release := saturate(t, e)
+	blocked := make(chan error)
+	go func() {
+		// blocked until the executor is stopped
+		blocked <- e.Execute(func() {})
+	}()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = e.TrySubmit(func() {})
+		}()
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	e.Stop()
+	e.Stop()
+	wg.Wait()
+	if err := <-blocked; err != nil && err != ErrExecutorStopped {
+		t.Fatalf("unexpected err:%v", err)
+	}
+	if err := e.Execute(func() {}); err != ErrExecutorStopped {
+		t.Fatalf("unexpected err:%v", err)
+	}
+	if err := e.TrySubmit(func() {}); err != ErrExecutorStopped {
+		t.Fatalf("unexpected err:%v", err)
+	}
+}
+
+func TestPriorityExecutor(t *testing.T) {
+	e := NewPriorityExecutor(32, 1).(PriorityExecutor)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := e.Execute(func() {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	var order []string
+	lock := sync.Mutex{}
+	for _, p := range []struct {
+		name     string
+		priority Priority
+	}{
+		{"batch", Priority{Class: -1, Value: 100}},
+		{"short", Priority{Value: 1}},
+		{"long", Priority{Value: 10}},
+		{"default", Priority{}},
+		{"interactive", Priority{Class: 1}},
+		{"short2", Priority{Value: 1}},
+	} {
+		name := p.name
+		if err := e.SubmitWithPriority(context.Background(), p.priority, func() {
+			lock.Lock()
+			defer lock.Unlock()
+			order = append(order, name)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+	e.Stop()
+	if fmt.Sprint(order) != "[interactive long short short2 default batch]" {
+		t.Fatalf("unexpected order:%v", order)
+	}
+	if err := e.Execute(func() {}); err != ErrExecutorStopped {
+		t.Fatalf("unexpected err:%v", err)
+	}
+
+	e = NewPriorityExecutor(1, 1, WithSaturationPolicy(PolicyReject)).(PriorityExecutor)
+	release = saturate(t, e)
+	if err := e.SubmitWithPriority(context.Background(), Priority{Class: 1}, func() {}); err != ErrRejected {
+		t.Fatalf("unexpected err:%v", err)
+	}
+	close(release)
+	e.Stop()
+}
+
+func TestWorkStealingExecutor(t *testing.T) {
+	e := NewWorkStealingExecutor(32, 2).(LocalExecutor)
+	// the local task must be stolen by the other worker, since its own worker is waiting for it
+	done := make(chan int, 2)
+	if err := e.SubmitLocal(context.Background(), NoWorker, func(worker int) {
+		stolen := make(chan struct{})
+		if err := e.SubmitLocal(context.Background(), worker, func(thief int) {
+			done <- thief
+			close(stolen)
+		}); err != nil {
+			t.Error(err)
+		}
+		<-stolen
+		done <- worker
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case thief := <-done:
+		if worker := <-done; thief == worker || thief == NoWorker || worker == NoWorker {
+			t.Fatalf("unexpected workers:%d, %d", thief, worker)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the local task isn't stolen")
+	}
+
+	// tasks fan out locally, and all of them are executed before Stop returns
+	var count atomic.Int32
+	var fanOut func(depth int) func(int)
+	fanOut = func(depth int) func(int) {
+		return func(worker int) {
+			count.Inc()
+			for i := 0; depth > 0 && i < 4; i++ {
+				if err := e.SubmitLocal(context.Background(), worker, fanOut(depth-1)); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	}
+	if err := e.SubmitLocal(context.Background(), NoWorker, fanOut(4)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000 && count.Load() != 341; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	e.Stop()
+	if count.Load() != 341 {
+		t.Fatalf("unexpected count:%d", count.Load())
+	}
+	if err := e.SubmitLocal(context.Background(), 0, func(int) {}); err != ErrExecutorStopped {
+		t.Fatalf("unexpected err:%v", err)
+	}
+
+	e = NewWorkStealingExecutor(1, 1, WithSaturationPolicy(PolicyReject)).(LocalExecutor)
+	release := saturate(t, e)
+	if err := e.Execute(func() {}); err != ErrRejected {
+		t.Fatalf("unexpected err:%v", err)
+	}
+	close(release)
+	e.Stop()
+}
+
+func TestResize(t *testing.T) {
+	e := NewDefaultExecutor(32, 2).(ResizableExecutor)
+	if stats := e.Stats(); stats.Workers != 2 || stats.Busy != 0 || stats.QueueDepth != 0 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+	release := make(chan struct{})
+	started := make(chan struct{}, 8)
+	block := func() {
+		started <- struct{}{}
+		<-release
+	}
+	for i := 0; i < 4; i++ {
+		if err := e.Execute(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-started
+	<-started
+	if err := e.Resize(4); err != nil {
+		t.Fatal(err)
+	}
+	// the queued tasks are executed by the new workers
+	<-started
+	<-started
+	if stats := e.Stats(); stats.Workers != 4 || stats.Busy != 4 || stats.QueueDepth != 0 || stats.WaitTime <= 0 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+	if err := e.Resize(1); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	for i := 0; i < 100 && e.Stats().Busy > 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := e.Stats(); stats.Workers != 1 || stats.Busy != 0 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+	done := make(chan struct{})
+	if err := e.Execute(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	e.Stop()
+	if err := e.Resize(2); err != ErrExecutorStopped {
+		t.Fatalf("unexpected err:%v", err)
+	}
+}
+
+func TestAutoscaling(t *testing.T) {
+	e := NewDefaultExecutor(32, 8, WithAutoscaling(1, 3, time.Millisecond, 20*time.Millisecond)).(ResizableExecutor)
+	if stats := e.Stats(); stats.Workers != 3 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+	e.Stop()
+
+	e = NewDefaultExecutor(32, 1, WithAutoscaling(1, 3, time.Millisecond, 20*time.Millisecond)).(ResizableExecutor)
+	defer e.Stop()
+	release := make(chan struct{})
+	defer close(release)
+	// tasks waiting longer than the target add workers up to the max
+	for i := 0; i < 6; i++ {
+		if err := e.Execute(func() {
+			<-release
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100 && e.Stats().Busy < 3; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := e.Stats(); stats.Workers != 3 || stats.Busy != 3 || stats.QueueDepth != 3 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+	for i := 0; i < 6; i++ {
+		release <- struct{}{}
+	}
+	// idle workers quit down to the min after all tasks are done
+	for i := 0; i < 200 && e.Stats().Workers > 1; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := e.Stats(); stats.Workers != 1 || stats.Busy != 0 || stats.QueueDepth != 0 {
+		t.Fatalf("unexpected stats:%+v", stats)
+	}
+}
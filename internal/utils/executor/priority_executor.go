@@ -0,0 +1,199 @@
+package executor
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// Priority of a task. Tasks of a higher class are executed first, and then the tasks with a higher value, tasks with
+// the same priority are executed in the order they are submitted.
+type Priority struct {
+	Class int
+	Value int64
+}
+
+func (p Priority) higherThan(o Priority) bool {
+	if p.Class != o.Class {
+		return p.Class > o.Class
+	}
+	return p.Value > o.Value
+}
+
+// PriorityExecutor is an optional interface of Executor, which executes the submitted tasks by their priorities.
+// Tasks submitted by the methods of Executor have the zero Priority.
+type PriorityExecutor interface {
+	Executor
+	SubmitWithPriority(ctx context.Context, priority Priority, task func()) error
+}
+
+type priorityTask struct {
+	priority Priority
+	seq      uint64
+	task     func()
+}
+
+// priorityQueue implements heap.Interface
+type priorityQueue []priorityTask
+
+func (q priorityQueue) Len() int {
+	return len(q)
+}
+func (q priorityQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority.higherThan(q[j].priority)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q priorityQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+func (q *priorityQueue) Push(x interface{}) {
+	*q = append(*q, x.(priorityTask))
+}
+func (q *priorityQueue) Pop() interface{} {
+	old := *q
+	t := old[len(old)-1]
+	old[len(old)-1] = priorityTask{}
+	*q = old[:len(old)-1]
+	return t
+}
+
+type PriorityExecutorImpl struct {
+	executorBase
+	lock     sync.Mutex
+	cond     *sync.Cond // signaled when a task is pushed or the executor is stopped
+	tasks    priorityQueue
+	seq      uint64
+	closed   bool
+	slots    chan struct{} // every queued task takes a slot, which bounds the queue to queueLength
+	stopped  chan struct{} // closed when Stop is called, which releases the blocked submissions
+	stopOnce sync.Once
+}
+
+// NewPriorityExecutor creates an executor which executes the task with the highest priority in its queue first.
+// The queueLength and the options are the same as NewDefaultExecutor.
+func NewPriorityExecutor(queueLength uint, concurrentLevel uint, opts ...Option) Executor {
+	if concurrentLevel == 0 {
+		concurrentLevel = 1
+	}
+
+	p := &PriorityExecutorImpl{
+		slots:   make(chan struct{}, queueLength),
+		stopped: make(chan struct{}),
+	}
+	p.cond = sync.NewCond(&p.lock)
+	p.init(concurrentLevel, opts)
+	for i := concurrentLevel; i > 0; i-- {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for {
+				task, ok := p.pop()
+				if !ok {
+					return
+				}
+				task()
+			}
+		}()
+	}
+	return p
+}
+
+// pop the task with the highest priority, it returns false after the executor is stopped and the queue is empty
+func (p *PriorityExecutorImpl) pop() (func(), bool) {
+	p.lock.Lock()
+	for len(p.tasks) == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if len(p.tasks) == 0 {
+		p.lock.Unlock()
+		return nil, false
+	}
+	t := heap.Pop(&p.tasks).(priorityTask)
+	p.lock.Unlock()
+	<-p.slots
+	return t.task, true
+}
+
+// push a task which has taken a slot
+func (p *PriorityExecutorImpl) push(priority Priority, task func()) error {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		<-p.slots
+		return ErrExecutorStopped
+	}
+	p.seq++
+	heap.Push(&p.tasks, priorityTask{priority: priority, seq: p.seq, task: task})
+	p.lock.Unlock()
+	p.cond.Signal()
+	return nil
+}
+
+func (p *PriorityExecutorImpl) isClosed() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.closed
+}
+
+func (p *PriorityExecutorImpl) Execute(task func()) error {
+	return p.SubmitWithPriority(context.Background(), Priority{}, task)
+}
+
+func (p *PriorityExecutorImpl) Submit(ctx context.Context, task func()) error {
+	return p.SubmitWithPriority(ctx, Priority{}, task)
+}
+
+func (p *PriorityExecutorImpl) TrySubmit(task func()) error {
+	if p.isClosed() {
+		return ErrExecutorStopped
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return p.push(Priority{}, task)
+	default:
+		return ErrRejected
+	}
+}
+
+func (p *PriorityExecutorImpl) SubmitWithPriority(ctx context.Context, priority Priority, task func()) error {
+	if p.isClosed() {
+		return ErrExecutorStopped
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return p.push(priority, task)
+	default:
+	}
+	switch p.policy {
+	case PolicyReject:
+		return ErrRejected
+	case PolicyCallerRuns:
+		task()
+		return nil
+	case PolicyGrow:
+		return p.runTemporarily(task)
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return p.push(priority, task)
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.stopped:
+		return ErrExecutorStopped
+	}
+}
+
+// Stop the executor after processing the remaining tasks in the queue. Tasks submitted after calling this function
+// are rejected with ErrExecutorStopped. It's safe to call Stop more than once.
+func (p *PriorityExecutorImpl) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopped)
+	})
+	p.lock.Lock()
+	p.closed = true
+	p.lock.Unlock()
+	p.cond.Broadcast()
+	p.wg.Wait()
+}
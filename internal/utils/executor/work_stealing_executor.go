@@ -0,0 +1,264 @@
+package executor
+
+import (
+	"context"
+	"go.uber.org/atomic"
+	"sync"
+)
+
+// NoWorker is the worker index of tasks executed outside the workers of a LocalExecutor, such as the tasks run by
+// PolicyCallerRuns, and of submissions from goroutines which aren't workers.
+const NoWorker = -1
+
+// the capacity of the local queue of every worker, local tasks exceeding it are submitted to the global queue
+const localQueueLength = 256
+
+// LocalExecutor is an optional interface of Executor, whose workers have their own queues. Tasks are executed with
+// the index of their worker, a task submitting other tasks with its worker index pushes them to the local queue of
+// the worker, which executes them first unless they are stolen by idle workers.
+type LocalExecutor interface {
+	Executor
+	SubmitLocal(ctx context.Context, worker int, task func(worker int)) error
+}
+
+// localQueue is a deque, its worker pushes and pops tasks at the back, and the others steal tasks from the front
+type localQueue struct {
+	lock  sync.Mutex
+	tasks []func(int)
+}
+
+func (q *localQueue) push(task func(int)) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.tasks) >= localQueueLength {
+		return false
+	}
+	q.tasks = append(q.tasks, task)
+	return true
+}
+
+func (q *localQueue) pop() func(int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.tasks) == 0 {
+		return nil
+	}
+	task := q.tasks[len(q.tasks)-1]
+	q.tasks[len(q.tasks)-1] = nil
+	q.tasks = q.tasks[:len(q.tasks)-1]
+	return task
+}
+
+func (q *localQueue) steal() func(int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.tasks) == 0 {
+		return nil
+	}
+	task := q.tasks[0]
+	q.tasks[0] = nil
+	q.tasks = q.tasks[1:]
+	return task
+}
+
+type WorkStealingExecutorImpl struct {
+	executorBase
+	queue    chan func(int) // the global queue
+	locals   []*localQueue
+	idle     atomic.Int32  // workers waiting for tasks
+	wake     chan struct{} // wakes idle workers to steal local tasks
+	lock     sync.RWMutex  // held by submissions for reading and by Stop for writing
+	closed   bool          // guarded by lock
+	stopped  chan struct{} // closed when Stop is called, which releases the blocked submissions
+	stopOnce sync.Once
+}
+
+// NewWorkStealingExecutor creates an executor of concurrentLevel workers, every worker has a local queue besides the
+// global queue of queueLength tasks. Workers execute their latest local tasks first, then the tasks in the global
+// queue, and steal the oldest local tasks of the others when they are idle. The options are the same as
+// NewDefaultExecutor, the saturation policy applies to the global queue.
+func NewWorkStealingExecutor(queueLength uint, concurrentLevel uint, opts ...Option) Executor {
+	if concurrentLevel == 0 {
+		concurrentLevel = 1
+	}
+
+	w := &WorkStealingExecutorImpl{
+		queue:   make(chan func(int), queueLength),
+		locals:  make([]*localQueue, concurrentLevel),
+		wake:    make(chan struct{}, concurrentLevel),
+		stopped: make(chan struct{}),
+	}
+	w.init(concurrentLevel, opts)
+	for i, _ := range w.locals {
+		w.locals[i] = &localQueue{}
+	}
+	for i, _ := range w.locals {
+		w.wg.Add(1)
+		go w.work(i)
+	}
+	return w
+}
+
+func (w *WorkStealingExecutorImpl) work(worker int) {
+	defer w.wg.Done()
+	for {
+		task := w.next(worker)
+		if task == nil {
+			return
+		}
+		task(worker)
+	}
+}
+
+// next returns the next task of a worker, it returns nil after the executor is stopped and the queues are empty
+func (w *WorkStealingExecutorImpl) next(worker int) func(int) {
+	for {
+		if task := w.locals[worker].pop(); task != nil {
+			return task
+		}
+		select {
+		case task, ok := <-w.queue:
+			if !ok {
+				return w.steal(worker)
+			}
+			return task
+		default:
+		}
+		if task := w.steal(worker); task != nil {
+			return task
+		}
+		// check again after being counted as idle, so that the local tasks pushed meanwhile wake it up
+		w.idle.Inc()
+		if task := w.steal(worker); task != nil {
+			w.idle.Dec()
+			return task
+		}
+		select {
+		case task, ok := <-w.queue:
+			w.idle.Dec()
+			if !ok {
+				return w.steal(worker)
+			}
+			return task
+		case <-w.wake:
+			w.idle.Dec()
+		}
+	}
+}
+
+// steal a task from the local queues of the other workers
+func (w *WorkStealingExecutorImpl) steal(worker int) func(int) {
+	for i := 1; i < len(w.locals); i++ {
+		if task := w.locals[(worker+i)%len(w.locals)].steal(); task != nil {
+			return task
+		}
+	}
+	return nil
+}
+
+func (w *WorkStealingExecutorImpl) wakeIdle() {
+	if w.idle.Load() > 0 {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *WorkStealingExecutorImpl) Execute(task func()) error {
+	return w.Submit(context.Background(), task)
+}
+
+func (w *WorkStealingExecutorImpl) Submit(ctx context.Context, task func()) error {
+	return w.submit(ctx, func(int) {
+		task()
+	})
+}
+
+// SubmitLocal pushes a task to the local queue of the worker, or submits it to the global queue if the worker is
+// NoWorker or its local queue is full.
+func (w *WorkStealingExecutorImpl) SubmitLocal(ctx context.Context, worker int, task func(worker int)) error {
+	if worker < 0 || worker >= len(w.locals) {
+		return w.submit(ctx, task)
+	}
+	w.lock.RLock()
+	if w.closed {
+		w.lock.RUnlock()
+		return ErrExecutorStopped
+	}
+	if w.locals[worker].push(task) {
+		w.lock.RUnlock()
+		w.wakeIdle()
+		return nil
+	}
+	w.lock.RUnlock()
+	return w.submit(ctx, task)
+}
+
+func (w *WorkStealingExecutorImpl) submit(ctx context.Context, task func(int)) error {
+	w.lock.RLock()
+	if w.closed {
+		w.lock.RUnlock()
+		return ErrExecutorStopped
+	}
+	select {
+	case w.queue <- task:
+		w.lock.RUnlock()
+		return nil
+	default:
+	}
+	switch w.policy {
+	case PolicyReject:
+		w.lock.RUnlock()
+		return ErrRejected
+	case PolicyCallerRuns:
+		// release the lock before running the task, which may submit other tasks
+		w.lock.RUnlock()
+		task(NoWorker)
+		return nil
+	case PolicyGrow:
+		defer w.lock.RUnlock()
+		return w.runTemporarily(func() {
+			task(NoWorker)
+		})
+	}
+	defer w.lock.RUnlock()
+	select {
+	case w.queue <- task:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.stopped:
+		return ErrExecutorStopped
+	}
+}
+
+func (w *WorkStealingExecutorImpl) TrySubmit(task func()) error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if w.closed {
+		return ErrExecutorStopped
+	}
+	select {
+	case w.queue <- func(int) { task() }:
+		return nil
+	default:
+		return ErrRejected
+	}
+}
+
+// Stop the executor after processing the remaining tasks in the queues. Tasks submitted after calling this function
+// are rejected with ErrExecutorStopped. It's safe to call Stop more than once.
+func (w *WorkStealingExecutorImpl) Stop() {
+	// release the blocked submissions first, otherwise they hold the lock
+	w.stopOnce.Do(func() {
+		close(w.stopped)
+	})
+	w.lock.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.lock.Unlock()
+	w.wg.Wait()
+}
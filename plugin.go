@@ -0,0 +1,109 @@
+package dage
+
+import (
+	"fmt"
+	"github.com/MisakiOfScut/go-dage/internal/core"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PluginAPIVersion is the version of the plugin api implemented by the engine, in the form of "major.minor".
+	// A plugin is compatible with the engine if its APIVersion has the same major version and a minor version not
+	// greater than the engine's.
+	PluginAPIVersion = "1.0"
+	// PluginSymbol is the name of the symbol looked up by LoadOperatorPlugin.
+	PluginSymbol = "DagePlugin"
+)
+
+// Plugin describes the operators provided by a go plugin. A plugin should export a variable named DagePlugin.
+// 	e.x.
+// 		package main
+//
+// 		var DagePlugin = dage.Plugin{
+// 			APIVersion: dage.PluginAPIVersion,
+// 			Name:       "ranking",
+// 			Version:    "1.2.0",
+// 			Operators: []dage.PluginOperator{
+// 				{Name: "ranking/score", New: newScoreOpr, Options: []dage.RegisterOption{
+// 					dage.WithOperatorDescription("scores items")}},
+// 			},
+// 		}
+//
+// 		go build -buildmode=plugin -o ranking.so
+//
+type Plugin struct {
+	APIVersion string
+	Name       string
+	Version    string
+	Operators  []PluginOperator
+}
+
+// PluginOperator is an operator provided by a plugin, which is registered as RegisterOperator(Name, New, Options...).
+type PluginOperator struct {
+	Name    string
+	New     NewOperatorFunction
+	Options []RegisterOption
+}
+
+// checkPluginAPIVersion returns an error if a plugin built with apiVersion is incompatible with the engine.
+func checkPluginAPIVersion(apiVersion string) error {
+	major, minor, err := parsePluginAPIVersion(apiVersion)
+	if err != nil {
+		return err
+	}
+	engineMajor, engineMinor, _ := parsePluginAPIVersion(PluginAPIVersion)
+	if major != engineMajor || minor > engineMinor {
+		return fmt.Errorf("plugin api version:%s is incompatible with engine api version:%s", apiVersion,
+			PluginAPIVersion)
+	}
+	return nil
+}
+
+func parsePluginAPIVersion(apiVersion string) (int, int, error) {
+	parts := strings.Split(apiVersion, ".")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("illegal plugin api version:%q", apiVersion)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("illegal plugin api version:%q", apiVersion)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("illegal plugin api version:%q", apiVersion)
+	}
+	return major, minor, nil
+}
+
+// operatorRegistry registers the operators of plugins, e.x. the global operator manager
+type operatorRegistry interface {
+	RegisterOperators(registrations ...core.OperatorRegistration) error
+}
+
+// registerPlugin checks the plugin and registers its operators. Nothing is registered if the check fails, or any of
+// the operators fails to be registered.
+func registerPlugin(registry operatorRegistry, path string, p *Plugin) error {
+	if p == nil {
+		return fmt.Errorf("plugin:%s, symbol %s is nil", path, PluginSymbol)
+	}
+	if err := checkPluginAPIVersion(p.APIVersion); err != nil {
+		return fmt.Errorf("plugin:%s(%s@%s), %v", path, p.Name, p.Version, err)
+	}
+	for i, _ := range p.Operators {
+		if len(p.Operators[i].Name) == 0 || p.Operators[i].New == nil {
+			return fmt.Errorf("plugin:%s(%s@%s), operator #%d has no name or new function", path, p.Name,
+				p.Version, i)
+		}
+	}
+	registrations := make([]core.OperatorRegistration, 0, len(p.Operators))
+	for i, _ := range p.Operators {
+		opr := &p.Operators[i]
+		registrations = append(registrations, core.OperatorRegistration{ID: opr.Name, New: opr.New,
+			Options: opr.Options})
+	}
+	if err := registry.RegisterOperators(registrations...); err != nil {
+		return fmt.Errorf("plugin:%s(%s@%s), register operators failed with err:%v", path, p.Name, p.Version, err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package dage
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// LoadOperatorPlugin opens a go plugin built with -buildmode=plugin, and registers the operators described by its
+// exported DagePlugin symbol, which can be a dage.Plugin variable or a func() *dage.Plugin.
+// The plugin must be built with the same version of go and this package as the host binary.
+// A plugin can't be unloaded, loading it again only registers its operators again.
+func LoadOperatorPlugin(path string) error {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return fmt.Errorf("open plugin:%s failed with err:%v", path, err)
+	}
+	sym, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return fmt.Errorf("plugin:%s, %v", path, err)
+	}
+	switch s := sym.(type) {
+	case *Plugin:
+		return registerPlugin(_globalOprMgr, path, s)
+	case func() *Plugin:
+		return registerPlugin(_globalOprMgr, path, s())
+	default:
+		return fmt.Errorf("plugin:%s, unexpected type:%T of symbol %s", path, sym, PluginSymbol)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package dage
+
+import "fmt"
+
+// LoadOperatorPlugin is only supported on linux.
+func LoadOperatorPlugin(path string) error {
+	return fmt.Errorf("load plugin:%s failed, go plugins are only supported on linux", path)
+}
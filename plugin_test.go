@@ -0,0 +1,70 @@
+package dage
+
+import (
+	"github.com/MisakiOfScut/go-dage/internal/core"
+	"testing"
+)
+
+func TestCheckPluginAPIVersion(t *testing.T) {
+	cases := map[string]bool{
+		PluginAPIVersion: true,
+		"1.1":            false, // requires features of a newer engine
+		"0.9":            false,
+		"2.0":            false,
+		"1":              false,
+		"1.x":            false,
+		"":               false,
+	}
+	for version, ok := range cases {
+		if err := checkPluginAPIVersion(version); (err == nil) != ok {
+			t.Fatalf("version:%q, unexpected err:%v", version, err)
+		}
+	}
+}
+
+func TestRegisterPlugin(t *testing.T) {
+	oprMgr := core.NewDefaultOperatorManager()
+	oprMgr.SetRejectDuplicate(true)
+	newOpr := newTestTypedOperator
+	p := &Plugin{APIVersion: "2.0", Name: "test", Version: "1", Operators: []PluginOperator{
+		{Name: "test_plugin/opr", New: newOpr}}}
+	if err := registerPlugin(oprMgr, "test.so", p); err == nil {
+		t.Fatal("incompatible plugin should be rejected")
+	}
+
+	p.APIVersion = PluginAPIVersion
+	p.Operators = append(p.Operators, PluginOperator{Name: "test_plugin/nil"})
+	if err := registerPlugin(oprMgr, "test.so", p); err == nil {
+		t.Fatal("operator without new function should be rejected")
+	}
+	if oprMgr.GetOperator("test_plugin/opr") != nil {
+		t.Fatal("nothing should be registered if the check fails")
+	}
+
+	// the operators registered before the failed one are rolled back
+	p.Operators[1] = PluginOperator{Name: core.BuiltinNoop, New: newOpr}
+	if err := registerPlugin(oprMgr, "test.so", p); err == nil {
+		t.Fatal("duplicated operator should be rejected")
+	}
+	if oprMgr.GetOperator("test_plugin/opr") != nil {
+		t.Fatal("nothing should be registered if registering fails")
+	}
+
+	p.Operators = p.Operators[:1]
+	p.Operators[0].Options = []RegisterOption{WithOperatorVersion("1"), WithOperatorDescription("from plugin")}
+	if err := registerPlugin(oprMgr, "test.so", p); err != nil {
+		t.Fatal(err)
+	}
+	for _, meta := range oprMgr.ListOperators() {
+		if meta.ID() == "test_plugin/opr@1" && meta.Description == "from plugin" {
+			return
+		}
+	}
+	t.Fatal("operator registered by plugin should be listed")
+}
+
+func TestLoadOperatorPlugin_NotExisted(t *testing.T) {
+	if err := LoadOperatorPlugin("/not/existed.so"); err == nil {
+		t.Fatal("loading a non-existent plugin should fail")
+	}
+}
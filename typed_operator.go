@@ -0,0 +1,249 @@
+package dage
+
+import (
+	"fmt"
+	"github.com/MisakiOfScut/go-dage/internal/utils/log"
+	"math"
+	"reflect"
+)
+
+const typedOperatorTag = "dage"
+
+// TypedOperator adapts a typed run function to Operator. The inputs and outputs of the operator are the exported
+// fields of In and Out, named by their `dage` tag or by the field name if the tag is absent.
+// Fields tagged with `dage:"-"` are ignored.
+// 	e.x.
+// 		type ScoreIn struct {
+// 			User  *User   `dage:"user"`
+// 			Items []*Item `dage:"items"`
+// 		}
+// 		type ScoreOut struct {
+// 			Scores []float64 `dage:"scores"`
+// 		}
+// 		dage.RegisterTypedOperator("score", func(ctx *dage.DAGContext, in ScoreIn) (ScoreOut, error) {...})
+//
+type TypedOperator[In any, Out any] struct {
+	name      string
+	run       func(ctx *DAGContext, in In) (Out, error)
+	in        In
+	inFields  *typedFields
+	outFields *typedFields
+}
+
+// NewTypedOperator returns a function creating TypedOperator objects, which can be passed to RegisterOperator.
+// It panics if In or Out is not a struct.
+func NewTypedOperator[In any, Out any](name string, run func(ctx *DAGContext, in In) (Out, error)) NewOperatorFunction {
+	inFields := newTypedFields(name, reflect.TypeOf((*In)(nil)).Elem())
+	outFields := newTypedFields(name, reflect.TypeOf((*Out)(nil)).Elem())
+	return func() Operator {
+		return &TypedOperator[In, Out]{name: name, run: run, inFields: inFields, outFields: outFields}
+	}
+}
+
+// RegisterTypedOperator is a shortcut of RegisterOperator(oprName, NewTypedOperator(oprName, run), opts...).
+func RegisterTypedOperator[In any, Out any](oprName string, run func(ctx *DAGContext, in In) (Out, error),
+	opts ...RegisterOption) error {
+	return RegisterOperator(oprName, NewTypedOperator(oprName, run), opts...)
+}
+
+func (p *TypedOperator[In, Out]) Name() string {
+	return p.name
+}
+
+func (p *TypedOperator[In, Out]) OnExecute(ctx *DAGContext) (map[string]interface{}, error) {
+	out, err := p.run(ctx, p.in)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make(map[string]interface{}, len(p.outFields.names))
+	v := reflect.ValueOf(&out).Elem()
+	for i, name := range p.outFields.names {
+		outputs[name] = v.Field(p.outFields.index[i]).Interface()
+	}
+	return outputs, nil
+}
+
+func (p *TypedOperator[In, Out]) InjectDepsData(key string, value interface{}) error {
+	idx, ok := p.inFields.lookup(key)
+	if !ok {
+		return fmt.Errorf("%s is not the input of %s", key, p.name)
+	}
+	field := reflect.ValueOf(&p.in).Elem().Field(idx)
+	if err := assignValue(field, value); err != nil {
+		return fmt.Errorf("operator:%s, input:%s %v", p.name, key, err)
+	}
+	return nil
+}
+
+func (p *TypedOperator[In, Out]) GetInputsID() []string {
+	return p.inFields.names
+}
+
+func (p *TypedOperator[In, Out]) GetOutputsID() []string {
+	return p.outFields.names
+}
+
+func (p *TypedOperator[In, Out]) GetInputsType() map[string]reflect.Type {
+	return p.inFields.types
+}
+
+func (p *TypedOperator[In, Out]) GetOutputsType() map[string]reflect.Type {
+	return p.outFields.types
+}
+
+func (p *TypedOperator[In, Out]) Reset() Operator {
+	var zero In
+	p.in = zero
+	return p
+}
+
+// typedFields is shared by all operator objects created by the same NewOperatorFunction
+type typedFields struct {
+	names []string
+	index []int // index[i] is the struct field index of names[i]
+	types map[string]reflect.Type
+}
+
+func newTypedFields(oprName string, t reflect.Type) *typedFields {
+	if t.Kind() != reflect.Struct {
+		log.Panicf("typed operator:%s, %s is not a struct", oprName, t.String())
+	}
+	fields := &typedFields{types: make(map[string]reflect.Type)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		name, ok := f.Tag.Lookup(typedOperatorTag)
+		if name == "-" {
+			continue
+		}
+		if !ok || len(name) == 0 {
+			name = f.Name
+		}
+		if _, existed := fields.lookup(name); existed {
+			log.Panicf("typed operator:%s, data name:%s is duplicated in %s", oprName, name, t.String())
+		}
+		fields.names = append(fields.names, name)
+		fields.index = append(fields.index, i)
+		fields.types[name] = f.Type
+	}
+	return fields
+}
+
+func (f *typedFields) lookup(name string) (int, bool) {
+	for i := range f.names {
+		if f.names[i] == name {
+			return f.index[i], true
+		}
+	}
+	return 0, false
+}
+
+// assignValue sets value to dst, numeric values are converted if the conversion is lossless
+func assignValue(dst reflect.Value, value interface{}) error {
+	if value == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	src := reflect.ValueOf(value)
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return nil
+	}
+	if isNumeric(src.Kind()) && isNumeric(dst.Kind()) {
+		if !convertNumeric(dst, src) {
+			return fmt.Errorf("value %v of type %s overflows or loses precision in %s", value, src.Type().String(),
+				dst.Type().String())
+		}
+		return nil
+	}
+	return fmt.Errorf("expects type %s, but got %s", dst.Type().String(), src.Type().String())
+}
+
+// convertNumeric sets the numeric src to dst, returns false if src is out of the range of dst or loses precision
+func convertNumeric(dst, src reflect.Value) bool {
+	switch {
+	case isInt(src.Kind()):
+		i := src.Int()
+		switch {
+		case isInt(dst.Kind()):
+			if dst.OverflowInt(i) {
+				return false
+			}
+			dst.SetInt(i)
+		case isUint(dst.Kind()):
+			if i < 0 || dst.OverflowUint(uint64(i)) {
+				return false
+			}
+			dst.SetUint(uint64(i))
+		default:
+			f := float64(i)
+			if dst.OverflowFloat(f) || f >= math.MaxInt64 || int64(f) != i ||
+				(dst.Kind() == reflect.Float32 && float64(float32(f)) != f) {
+				return false
+			}
+			dst.SetFloat(f)
+		}
+	case isUint(src.Kind()):
+		u := src.Uint()
+		switch {
+		case isInt(dst.Kind()):
+			if u > math.MaxInt64 || dst.OverflowInt(int64(u)) {
+				return false
+			}
+			dst.SetInt(int64(u))
+		case isUint(dst.Kind()):
+			if dst.OverflowUint(u) {
+				return false
+			}
+			dst.SetUint(u)
+		default:
+			f := float64(u)
+			if dst.OverflowFloat(f) || f >= math.MaxUint64 || uint64(f) != u ||
+				(dst.Kind() == reflect.Float32 && float64(float32(f)) != f) {
+				return false
+			}
+			dst.SetFloat(f)
+		}
+	default:
+		f := src.Float()
+		switch {
+		case isInt(dst.Kind()):
+			if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 || dst.OverflowInt(int64(f)) {
+				return false
+			}
+			dst.SetInt(int64(f))
+		case isUint(dst.Kind()):
+			if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 || dst.OverflowUint(uint64(f)) {
+				return false
+			}
+			dst.SetUint(uint64(f))
+		default:
+			// NaN is kept as NaN
+			if dst.OverflowFloat(f) || (dst.Kind() == reflect.Float32 && float64(float32(f)) != f && !math.IsNaN(f)) {
+				return false
+			}
+			dst.SetFloat(f)
+		}
+	}
+	return true
+}
+
+func isInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUint(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isNumeric(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
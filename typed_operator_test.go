@@ -0,0 +1,177 @@
+package dage
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type typedIn struct {
+	User  string `dage:"user"`
+	Count int64  `dage:"count"`
+	Skip  string `dage:"-"`
+	inner int
+}
+
+type typedOut struct {
+	Greeting string `dage:"greeting"`
+	Total    int64
+}
+
+func newTestTypedOperator() Operator {
+	return NewTypedOperator("typed", func(ctx *DAGContext, in typedIn) (typedOut, error) {
+		if len(in.User) == 0 {
+			return typedOut{}, fmt.Errorf("empty user")
+		}
+		return typedOut{Greeting: "hello " + in.User, Total: in.Count * 2}, nil
+	})()
+}
+
+func TestTypedOperator_InputsOutputs(t *testing.T) {
+	opr := newTestTypedOperator()
+	if fmt.Sprint(opr.GetInputsID()) != "[user count]" {
+		t.Fatalf("unexpected inputs:%v", opr.GetInputsID())
+	}
+	if fmt.Sprint(opr.GetOutputsID()) != "[greeting Total]" {
+		t.Fatalf("unexpected outputs:%v", opr.GetOutputsID())
+	}
+}
+
+func TestTypedOperator_Execute(t *testing.T) {
+	opr := newTestTypedOperator()
+	if err := opr.InjectDepsData("user", "misaki"); err != nil {
+		t.Fatal(err)
+	}
+	if err := opr.InjectDepsData("count", 21); err != nil { // int is converted to int64
+		t.Fatal(err)
+	}
+	outputs, err := opr.OnExecute(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputs["greeting"] != "hello misaki" || outputs["Total"] != int64(42) {
+		t.Fatalf("unexpected outputs:%v", outputs)
+	}
+
+	opr = opr.Reset()
+	if _, err = opr.OnExecute(nil); err == nil {
+		t.Fatal("inputs should be cleared after reset")
+	}
+}
+
+func TestTypedOperator_InjectMismatch(t *testing.T) {
+	opr := newTestTypedOperator()
+	err := opr.InjectDepsData("user", 1)
+	if err == nil || !strings.Contains(err.Error(), "expects type string, but got int") {
+		t.Fatalf("unexpected err:%v", err)
+	}
+	if err = opr.InjectDepsData("count", 1.5); err == nil {
+		t.Fatal("lossy conversion should fail")
+	}
+	if err = opr.InjectDepsData("Skip", "x"); err == nil {
+		t.Fatal("ignored field shouldn't be an input")
+	}
+}
+
+func TestAssignValue_Numeric(t *testing.T) {
+	cases := []struct {
+		value    interface{}
+		dst      interface{}
+		expected interface{} // nil if the conversion should fail
+	}{
+		{21, int64(0), int64(21)},
+		{int64(300), uint8(0), nil},
+		{int64(-129), int8(0), nil},
+		{int64(127), int8(0), int8(127)},
+		{-1, uint64(0), nil},
+		{int8(-1), uint(0), nil},
+		{int64(-1), uint32(0), nil},
+		{7, uint16(0), uint16(7)},
+		{uint64(math.MaxUint64), int64(0), nil},
+		{uint64(math.MaxInt64), int64(0), int64(math.MaxInt64)},
+		{uint32(70000), uint16(0), nil},
+		{int64(1<<53 + 1), float64(0), nil},
+		{1 << 24, float32(0), float32(1 << 24)},
+		{1<<24 + 1, float32(0), nil},
+		{1.5, int64(0), nil},
+		{-1.0, uint(0), nil},
+		{1e19, int64(0), nil},
+		{1e20, uint64(0), nil},
+		{2.0, uint8(0), uint8(2)},
+		{0.1, float32(0), nil},
+		{0.5, float32(0), float32(0.5)},
+		{math.MaxFloat64, float32(0), nil},
+		{float32(0.1), float64(0), float64(float32(0.1))},
+	}
+	for _, c := range cases {
+		dst := reflect.New(reflect.TypeOf(c.dst)).Elem()
+		err := assignValue(dst, c.value)
+		if c.expected == nil {
+			if err == nil {
+				t.Fatalf("%T(%v) to %T should fail, but got %v", c.value, c.value, c.dst, dst.Interface())
+			}
+			continue
+		}
+		if err != nil || dst.Interface() != c.expected {
+			t.Fatalf("%T(%v) to %T, unexpected value:%v, err:%v", c.value, c.value, c.dst, dst.Interface(), err)
+		}
+	}
+}
+
+func TestRegisterTypedOperator(t *testing.T) {
+	RegisterTypedOperator("typed_producer", func(ctx *DAGContext, in struct{}) (typedIn, error) {
+		return typedIn{User: "misaki", Count: 1}, nil
+	})
+	done := make(chan typedOut, 1)
+	RegisterTypedOperator("typed_consumer", func(ctx *DAGContext, in typedIn) (typedOut, error) {
+		out := typedOut{Greeting: "hello " + in.User, Total: in.Count}
+		done <- out
+		return out, nil
+	})
+	script := `
+[[graph]]
+name = "typed"
+[[graph.vertex]]
+op = "typed_producer"
+[[graph.vertex]]
+op = "typed_consumer"
+`
+	if err := BuildAndSetDAG("typed_cluster", &script); err != nil {
+		t.Fatal(err)
+	}
+	if err := Execute(nil, "typed_cluster", "typed", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out := <-done; out.Greeting != "hello misaki" || out.Total != 1 {
+		t.Fatalf("unexpected output:%+v", out)
+	}
+}
+
+type wrongTypedIn struct {
+	User int `dage:"user"`
+}
+
+func TestRegisterTypedOperator_TypeMismatch(t *testing.T) {
+	RegisterTypedOperator("typed_wrong_producer", func(ctx *DAGContext, in struct{}) (wrongTypedIn, error) {
+		return wrongTypedIn{}, nil
+	})
+	RegisterTypedOperator("typed_string_consumer", func(ctx *DAGContext, in struct {
+		User string `dage:"user"`
+	}) (struct{}, error) {
+		return struct{}{}, nil
+	})
+	script := `
+[[graph]]
+name = "typed"
+[[graph.vertex]]
+op = "typed_wrong_producer"
+[[graph.vertex]]
+op = "typed_string_consumer"
+`
+	err := BuildAndSetDAG("typed_cluster_mismatch", &script)
+	if err == nil || !strings.Contains(err.Error(), "data id:user expects type string, but vertex:typed_wrong_producer") {
+		t.Fatalf("unexpected err:%v", err)
+	}
+}